@@ -0,0 +1,12 @@
+package config
+
+import "os"
+
+// AppURL is the frontend base URL used for links in emails.
+func AppURL() string {
+    url := os.Getenv("APP_URL")
+    if url == "" {
+        url = "http://localhost:3000"
+    }
+    return url
+}
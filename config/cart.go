@@ -0,0 +1,17 @@
+package config
+
+import (
+    "os"
+    "strconv"
+    "time"
+)
+
+// CartHoldDuration is how long items added to a cart stay reserved before the
+// sweeper gives them back to the ticket category.
+func CartHoldDuration() time.Duration {
+    minutes, err := strconv.Atoi(os.Getenv("CART_HOLD_MINUTES"))
+    if err != nil || minutes <= 0 {
+        minutes = 15
+    }
+    return time.Duration(minutes) * time.Minute
+}
@@ -0,0 +1,17 @@
+package config
+
+import (
+    "os"
+    "strconv"
+    "time"
+)
+
+// PaymentTimeout is how long an order waits for payment before it expires and
+// its tickets go back on sale.
+func PaymentTimeout() time.Duration {
+    minutes, err := strconv.Atoi(os.Getenv("PAYMENT_TIMEOUT_MINUTES"))
+    if err != nil || minutes <= 0 {
+        minutes = 30
+    }
+    return time.Duration(minutes) * time.Minute
+}
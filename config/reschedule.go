@@ -0,0 +1,17 @@
+package config
+
+import (
+    "os"
+    "strconv"
+    "time"
+)
+
+// RescheduleRefundWindow is how long ticket holders have to ask for a refund
+// after an event they bought tickets for is moved.
+func RescheduleRefundWindow() time.Duration {
+    days, err := strconv.Atoi(os.Getenv("RESCHEDULE_REFUND_DAYS"))
+    if err != nil || days <= 0 {
+        days = 14
+    }
+    return time.Duration(days) * 24 * time.Hour
+}
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+const maxAttendeeFields = 10
+
+type AttendeeRequest struct {
+    TicketCategoryID string            `json:"ticket_category_id"`
+    Name             string            `json:"name"`
+    Email            string            `json:"email"`
+    IDNumber         string            `json:"id_number"`
+    CustomFields     map[string]string `json:"custom_fields"`
+    Answers          []AnswerRequest   `json:"answers"`
+}
+
+// validateAttendeeFields cleans up the custom field labels an EO asks for.
+func validateAttendeeFields(fields []string) ([]string, string) {
+    if len(fields) > maxAttendeeFields {
+        return nil, "A category can ask for at most 10 custom attendee fields"
+    }
+
+    cleaned := make([]string, 0, len(fields))
+    seen := map[string]bool{}
+    for _, field := range fields {
+        field = strings.TrimSpace(field)
+        if field == "" || len(field) > 100 {
+            return nil, "Attendee field labels must be between 1 and 100 characters"
+        }
+        if seen[strings.ToLower(field)] {
+            return nil, "Attendee field labels must be unique"
+        }
+        seen[strings.ToLower(field)] = true
+        cleaned = append(cleaned, field)
+    }
+    return cleaned, ""
+}
+
+// validateAttendee checks attendee details against what the category asks for
+// and returns the attendee to store, or a message explaining what is missing.
+func validateAttendee(ticketCategory *models.TicketCategory, req *AttendeeRequest) (*models.Attendee, string) {
+    name := strings.TrimSpace(req.Name)
+    if name == "" || len(name) > 200 {
+        return nil, "Attendee name is required"
+    }
+
+    email := strings.TrimSpace(req.Email)
+    if email != "" && (!strings.Contains(email, "@") || len(email) > 150) {
+        return nil, "Attendee email is not valid"
+    }
+
+    idNumber := strings.TrimSpace(req.IDNumber)
+    if ticketCategory.RequireIDNumber && idNumber == "" {
+        return nil, "Attendee ID number is required for " + ticketCategory.Name
+    }
+    if len(idNumber) > 100 {
+        return nil, "Attendee ID number is too long"
+    }
+
+    // Only the fields the category declares are kept, and all of them are required
+    customFields := map[string]string{}
+    for _, field := range ticketCategory.AttendeeFields {
+        value := strings.TrimSpace(req.CustomFields[field])
+        if value == "" {
+            return nil, "Attendee field " + field + " is required"
+        }
+        if len(value) > 500 {
+            return nil, "Attendee field " + field + " is too long"
+        }
+        customFields[field] = value
+    }
+
+    return &models.Attendee{
+        EventID:          ticketCategory.EventID,
+        TicketCategoryID: ticketCategory.TicketCategoryID,
+        Name:             name,
+        Email:            email,
+        IDNumber:         idNumber,
+        CustomFields:     customFields,
+    }, ""
+}
+
+// attendeeCutoff is when attendee details of the category stop being
+// editable: the configured cutoff, or the start of the event.
+func attendeeCutoff(tx *gorm.DB, ticketCategory *models.TicketCategory) time.Time {
+    if ticketCategory.AttendeeCutoff != nil {
+        return *ticketCategory.AttendeeCutoff
+    }
+
+    var event models.Event
+    if err := tx.Select("date_start").Where("event_id = ?", ticketCategory.EventID).First(&event).Error; err != nil {
+        return time.Now()
+    }
+    return event.DateStart
+}
+
+// saveOrderAttendees stores attendee details given at checkout against the
+// order items they belong to. Attendees without a category go to the only
+// item in the order.
+func saveOrderAttendees(tx *gorm.DB, items []models.OrderItem, attendees []AttendeeRequest) error {
+    if len(attendees) == 0 {
+        return nil
+    }
+
+    positions := map[string]int{}
+    for i := range attendees {
+        req := &attendees[i]
+        if req.TicketCategoryID == "" && len(items) == 1 {
+            req.TicketCategoryID = items[0].TicketCategoryID
+        }
+
+        var item *models.OrderItem
+        for j := range items {
+            if items[j].TicketCategoryID == req.TicketCategoryID && items[j].ResaleListingID == nil {
+                item = &items[j]
+                break
+            }
+        }
+        if item == nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Attendee does not match a ticket category in the order")
+        }
+
+        if positions[item.OrderItemID] >= item.Quantity {
+            return fiber.NewError(fiber.StatusBadRequest, "More attendees than tickets for a ticket category")
+        }
+
+        var ticketCategory models.TicketCategory
+        if err := tx.Where("ticket_category_id = ?", item.TicketCategoryID).First(&ticketCategory).Error; err != nil {
+            return err
+        }
+
+        attendee, msg := validateAttendee(&ticketCategory, req)
+        if msg != "" {
+            return fiber.NewError(fiber.StatusBadRequest, msg)
+        }
+        attendee.OrderItemID = &item.OrderItemID
+        attendee.Position = positions[item.OrderItemID]
+        positions[item.OrderItemID]++
+
+        if err := tx.Create(attendee).Error; err != nil {
+            return err
+        }
+
+        if err := saveAttendeeAnswers(tx, attendee, item.OrderID, req.Answers); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// assignAttendees links attendees collected at checkout to the tickets minted
+// for their order item, in the order they were given.
+func assignAttendees(tx *gorm.DB, item models.OrderItem, tickets []models.Ticket) error {
+    var attendees []models.Attendee
+    if err := tx.Where("order_item_id = ? AND ticket_id IS NULL", item.OrderItemID).Order("position").Find(&attendees).Error; err != nil {
+        return err
+    }
+
+    for i, attendee := range attendees {
+        if i >= len(tickets) {
+            break
+        }
+        if err := tx.Model(&models.Attendee{}).Where("attendee_id = ?", attendee.AttendeeID).Update("ticket_id", tickets[i].TicketID).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// clearAttendee drops the attendee details and answers of a ticket that
+// changes hands, so the new holder enters their own.
+func clearAttendee(tx *gorm.DB, ticketID string) error {
+    var attendee models.Attendee
+    if err := tx.Where("ticket_id = ?", ticketID).First(&attendee).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil
+        }
+        return err
+    }
+
+    if err := tx.Where("attendee_id = ?", attendee.AttendeeID).Delete(&models.RegistrationAnswer{}).Error; err != nil {
+        return err
+    }
+    return tx.Delete(&attendee).Error
+}
+
+// attendeeDetails adds the attendee of a scanned ticket to a gate response, and
+// flags tickets whose category requires details that were never given.
+func attendeeDetails(response fiber.Map, ticket *models.Ticket) {
+    var attendee models.Attendee
+    if config.DB.Where("ticket_id = ?", ticket.TicketID).First(&attendee).Error == nil {
+        response["attendee"] = attendee
+        return
+    }
+
+    var ticketCategory models.TicketCategory
+    if config.DB.Select("require_attendee").Where("ticket_category_id = ?", ticket.TicketCategoryID).First(&ticketCategory).Error == nil && ticketCategory.RequireAttendee {
+        response["attendee_missing"] = true
+    }
+}
+
+func UpdateTicketAttendee(c *fiber.Ctx) error {
+    ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    var ticket models.Ticket
+    if err := config.DB.Where("ticket_id = ? AND owner_id = ?", ticketID, userID).First(&ticket).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Ticket not found",
+        })
+    }
+
+    if ticket.Status != "active" || ticket.CheckedInAt != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Attendee details can only be changed on unused tickets",
+        })
+    }
+
+    var ticketCategory models.TicketCategory
+    if err := config.DB.Where("ticket_category_id = ?", ticket.TicketCategoryID).First(&ticketCategory).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Ticket category not found",
+        })
+    }
+
+    if cutoff := attendeeCutoff(config.DB, &ticketCategory); !time.Now().Before(cutoff) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Attendee details can no longer be changed",
+        })
+    }
+
+    var req AttendeeRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    attendee, msg := validateAttendee(&ticketCategory, &req)
+    if msg != "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": msg,
+        })
+    }
+    attendee.TicketID = &ticket.TicketID
+
+    var existing models.Attendee
+    if config.DB.Where("ticket_id = ?", ticket.TicketID).First(&existing).Error == nil {
+        attendee.AttendeeID = existing.AttendeeID
+        attendee.OrderItemID = existing.OrderItemID
+        attendee.Position = existing.Position
+        attendee.CreatedAt = existing.CreatedAt
+    }
+
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Save(attendee).Error; err != nil {
+            return err
+        }
+        return saveAttendeeAnswers(tx, attendee, ticket.OrderID, req.Answers)
+    })
+
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        return c.Status(fiberErr.Code).JSON(fiber.Map{
+            "error": fiberErr.Message,
+        })
+    }
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to save attendee details",
+        })
+    }
+
+    // Gate devices pick up the new name with their next manifest delta
+    config.DB.Model(&ticket).Update("updated_at", time.Now())
+
+    return c.JSON(fiber.Map{
+        "message":  "Attendee details saved",
+        "attendee": attendee,
+    })
+}
@@ -1,8 +1,14 @@
 package controllers
 
 import (
+    "errors"
+    "log"
+    "time"
+
     "github.com/gofiber/fiber/v2"
     "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
     "ticketing-backend/config"
     "ticketing-backend/models"
     "ticketing-backend/utils"
@@ -74,6 +80,10 @@ func Register(c *fiber.Ctx) error {
         })
     }
 
+    if err := sendVerificationEmail(&user); err != nil {
+        log.Println("Failed to send verification email:", err)
+    }
+
     return c.Status(fiber.StatusCreated).JSON(fiber.Map{
         "message": "User registered successfully",
         "user": fiber.Map{
@@ -83,6 +93,7 @@ func Register(c *fiber.Ctx) error {
             "email":     user.Email,
             "role":      user.Role,
             "register_status": user.RegisterStatus,
+            "email_verified":  false,
         },
     })
 }
@@ -116,8 +127,7 @@ func Login(c *fiber.Ctx) error {
         })
     }
 
-    // Generate JWT
-    token, err := utils.GenerateJWT(user.UserID, user.Role)
+    accessToken, refreshToken, err := createSession(config.DB, &user, "", c.Get("User-Agent"))
     if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to generate token",
@@ -125,14 +135,171 @@ func Login(c *fiber.Ctx) error {
     }
 
     return c.JSON(fiber.Map{
-        "message": "Login successful",
-        "token":   token,
+        "message":       "Login successful",
+        "token":         accessToken,
+        "access_token":  accessToken,
+        "refresh_token": refreshToken,
+        "expires_in":    int(utils.AccessTokenTTL().Seconds()),
         "user": fiber.Map{
             "user_id":   user.UserID,
             "username":  user.Username,
             "name":      user.Name,
             "email":     user.Email,
             "role":      user.Role,
+            "email_verified": user.EmailVerifiedAt != nil,
         },
     })
-}
\ No newline at end of file
+}
+
+type RefreshRequest struct {
+    RefreshToken string `json:"refresh_token"`
+}
+
+// createSession stores a new session for the user and returns its access and
+// refresh tokens. An empty familyID starts a new login.
+func createSession(tx *gorm.DB, user *models.User, familyID, userAgent string) (string, string, error) {
+    refreshToken, err := utils.GenerateOpaqueToken()
+    if err != nil {
+        return "", "", err
+    }
+
+    if len(userAgent) > 255 {
+        userAgent = userAgent[:255]
+    }
+
+    session := models.Session{
+        UserID:           user.UserID,
+        FamilyID:         familyID,
+        RefreshTokenHash: utils.HashToken(refreshToken),
+        UserAgent:        userAgent,
+        ExpiresAt:        time.Now().Add(utils.RefreshTokenTTL()),
+    }
+    if err := tx.Create(&session).Error; err != nil {
+        return "", "", err
+    }
+
+    accessToken, err := utils.GenerateJWT(user.UserID, user.Role, session.SessionID)
+    if err != nil {
+        return "", "", err
+    }
+
+    return accessToken, refreshToken, nil
+}
+
+// revokeSessions ends every session of the user except the login identified
+// by exceptFamilyID.
+func revokeSessions(tx *gorm.DB, userID, exceptFamilyID string) error {
+    query := tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+    if exceptFamilyID != "" {
+        query = query.Where("family_id <> ?", exceptFamilyID)
+    }
+    return query.Update("revoked_at", time.Now()).Error
+}
+
+func Refresh(c *fiber.Ctx) error {
+    var req RefreshRequest
+    if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Refresh token is required",
+        })
+    }
+
+    var accessToken, refreshToken string
+    reused := false
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var session models.Session
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("refresh_token_hash = ?", utils.HashToken(req.RefreshToken)).
+            First(&session).Error; err != nil {
+            return err
+        }
+
+        now := time.Now()
+
+        // A rotated token showing up again means it was stolen, so end
+        // every session that descends from the same login
+        if session.RotatedAt != nil {
+            reused = true
+            return tx.Model(&models.Session{}).
+                Where("family_id = ? AND revoked_at IS NULL", session.FamilyID).
+                Update("revoked_at", now).Error
+        }
+
+        if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+            return gorm.ErrRecordNotFound
+        }
+
+        var user models.User
+        if err := tx.Where("user_id = ?", session.UserID).First(&user).Error; err != nil {
+            return err
+        }
+
+        if err := tx.Model(&session).Update("rotated_at", now).Error; err != nil {
+            return err
+        }
+
+        var err error
+        accessToken, refreshToken, err = createSession(tx, &user, session.FamilyID, c.Get("User-Agent"))
+        return err
+    })
+
+    if reused {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Refresh token reuse detected, please log in again",
+        })
+    }
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Invalid refresh token",
+        })
+    }
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to refresh token",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message":       "Token refreshed successfully",
+        "token":         accessToken,
+        "access_token":  accessToken,
+        "refresh_token": refreshToken,
+        "expires_in":    int(utils.AccessTokenTTL().Seconds()),
+    })
+}
+
+func Logout(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    sessionID := c.Locals("sessionID").(string)
+
+    // Logging out from every device is opt-in
+    if c.QueryBool("all") {
+        if err := revokeSessions(config.DB, userID, ""); err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "error": "Failed to log out",
+            })
+        }
+        return c.JSON(fiber.Map{
+            "message": "Logged out from all sessions",
+        })
+    }
+
+    var session models.Session
+    if err := config.DB.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Session not found",
+        })
+    }
+
+    if err := config.DB.Model(&models.Session{}).
+        Where("family_id = ? AND revoked_at IS NULL", session.FamilyID).
+        Update("revoked_at", time.Now()).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to log out",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Logged out successfully",
+    })
+}
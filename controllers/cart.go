@@ -1,18 +1,162 @@
 package controllers
 
 import (
+    "errors"
+    "log"
+    "time"
+
     "github.com/gofiber/fiber/v2"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
     "ticketing-backend/config"
     "ticketing-backend/models"
-    "github.com/google/uuid"
 )
 
+type CheckoutRequest struct {
+    Attendees []AttendeeRequest `json:"attendees"`
+    Answers   []AnswerRequest   `json:"answers"`
+}
+
+// AddToCartRequest adds either tickets of a category or a single resale
+// listing, given by ListingID.
 type AddToCartRequest struct {
     TicketCategoryID string `json:"ticket_category_id"`
+    ListingID        string `json:"listing_id"`
     Quantity         int    `json:"quantity"`
 }
 
+// releaseCartHold gives back what a cart row holds: tickets of a category or
+// a resale listing.
+func releaseCartHold(tx *gorm.DB, cart *models.Cart) error {
+    if cart.ResaleListingID != nil {
+        return releaseHeldListing(tx, *cart.ResaleListingID)
+    }
+    return releaseHeldTickets(tx, cart.TicketCategoryID, cart.Quantity)
+}
+
+// removeExpiredCartItem deletes a cart row whose hold ran out and gives the
+// hold back. The expiry is checked again in the delete, so a row refreshed in
+// the meantime is kept and false is returned.
+func removeExpiredCartItem(tx *gorm.DB, cart *models.Cart) (bool, error) {
+    result := tx.Where("cart_id = ? AND expires_at <= ?", cart.CartID, time.Now()).Delete(&models.Cart{})
+    if result.Error != nil || result.RowsAffected == 0 {
+        return false, result.Error
+    }
+    return true, releaseCartHold(tx, cart)
+}
+
+// addCartItem stores a cart row whose tickets are already held and starts its
+// hold window. Concurrent adds of the same item end up on one row: the first
+// creates it, the others add their quantity and restart the window. cart is
+// reloaded with the stored row.
+func addCartItem(tx *gorm.DB, cart *models.Cart) error {
+    cart.ExpiresAt = time.Now().Add(config.CartHoldDuration())
+    if err := tx.Clauses(clause.OnConflict{
+        DoUpdates: clause.Assignments(map[string]interface{}{
+            "quantity":   gorm.Expr("quantity + ?", cart.Quantity),
+            "expires_at": cart.ExpiresAt,
+            "updated_at": time.Now(),
+        }),
+    }).Create(cart).Error; err != nil {
+        return err
+    }
+
+    return tx.Where("user_id = ? AND ticket_category_id = ? AND item_key = ?", cart.UserID, cart.TicketCategoryID, cart.ItemKey).First(cart).Error
+}
+
+// restoreCart cancels an order that could not be charged and puts its items
+// back in the buyer's cart, still holding the same tickets, so checkout can be
+// tried again.
+func restoreCart(order *models.Order, items []models.OrderItem) error {
+    return config.DB.Transaction(func(tx *gorm.DB) error {
+        result := tx.Model(&models.Order{}).
+            Where("order_id = ? AND status = ?", order.OrderID, "pending_payment").
+            Update("status", "cancelled")
+        if result.Error != nil {
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            return ErrNotEnoughTickets
+        }
+
+        for _, item := range items {
+            cart := models.Cart{
+                UserID:           order.UserID,
+                TicketCategoryID: item.TicketCategoryID,
+                ResaleListingID:  item.ResaleListingID,
+                Quantity:         item.Quantity,
+            }
+            if item.ResaleListingID != nil {
+                if err := unsellHeldListing(tx, *item.ResaleListingID, order.OrderID); err != nil {
+                    return err
+                }
+            } else if err := unsellHeldTickets(tx, item.TicketCategoryID, item.Quantity); err != nil {
+                return err
+            }
+            if err := addCartItem(tx, &cart); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// dropExpiredCartItems removes the rows of a user's cart whose hold ran out
+// and returns them.
+func dropExpiredCartItems(userID string) ([]models.Cart, error) {
+    var expired []models.Cart
+    if err := config.DB.Where("user_id = ? AND expires_at <= ?", userID, time.Now()).Find(&expired).Error; err != nil {
+        return nil, err
+    }
+
+    dropped := []models.Cart{}
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        for i := range expired {
+            deleted, err := removeExpiredCartItem(tx, &expired[i])
+            if err != nil {
+                return err
+            }
+            if deleted {
+                dropped = append(dropped, expired[i])
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return dropped, nil
+}
+
+// removeCartItem deletes a cart row and gives its hold back. Every path that
+// drops a cart row goes through here so held stays equal to the cart contents.
+func removeCartItem(tx *gorm.DB, cart *models.Cart) error {
+    result := tx.Where("cart_id = ?", cart.CartID).Delete(&models.Cart{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return nil
+    }
+    return releaseCartHold(tx, cart)
+}
+
+func GetCart(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var cartItems []models.Cart
+    if err := config.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("created_at").Find(&cartItems).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch cart items",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "cart":        cartItems,
+        "server_time": time.Now(),
+    })
+}
+
 func AddToCart(c *fiber.Ctx) error {
     userID := c.Locals("userID").(string)
 
@@ -23,6 +167,16 @@ func AddToCart(c *fiber.Ctx) error {
         })
     }
 
+    if req.ListingID != "" {
+        return addListingToCart(c, userID, req.ListingID)
+    }
+
+    if req.Quantity <= 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Quantity must be greater than 0",
+        })
+    }
+
     // Check if ticket category exists
     var ticketCategory models.TicketCategory
     if err := config.DB.Where("ticket_category_id = ?", req.TicketCategoryID).First(&ticketCategory).Error; err != nil {
@@ -31,35 +185,98 @@ func AddToCart(c *fiber.Ctx) error {
         })
     }
 
-    // Check if item already in cart
-    var existingCart models.Cart
-    if err := config.DB.Where("user_id = ? AND ticket_category_id = ?", userID, req.TicketCategoryID).First(&existingCart).Error; err == nil {
-        // Update quantity if exists
-        existingCart.Quantity += req.Quantity
-        if err := config.DB.Save(&existingCart).Error; err != nil {
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "error": "Failed to update cart",
-            })
-        }
-        return c.JSON(fiber.Map{
-            "message": "Cart updated successfully",
+    if ticketCategory.Status == "retired" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Ticket category is no longer on sale",
         })
     }
 
-    cart := models.Cart{
-        UserID:           userID,
-        TicketCategoryID: req.TicketCategoryID,
-        Quantity:         req.Quantity,
+    if !eventOnSale(config.DB, ticketCategory.EventID) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Event is not on sale",
+        })
     }
 
-    if err := config.DB.Create(&cart).Error; err != nil {
+    var cart models.Cart
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        // A hold that ran out is given back before a new one starts
+        var existing models.Cart
+        if err := tx.Where("user_id = ? AND ticket_category_id = ? AND item_key = ? AND expires_at <= ?", userID, req.TicketCategoryID, "", time.Now()).
+            First(&existing).Error; err == nil {
+            if _, err := removeExpiredCartItem(tx, &existing); err != nil {
+                return err
+            }
+        }
+
+        if err := holdTickets(tx, req.TicketCategoryID, req.Quantity); err != nil {
+            return err
+        }
+
+        cart = models.Cart{
+            UserID:           userID,
+            TicketCategoryID: req.TicketCategoryID,
+            Quantity:         req.Quantity,
+        }
+        return addCartItem(tx, &cart)
+    })
+
+    if errors.Is(err, ErrNotEnoughTickets) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Not enough tickets available",
+        })
+    }
+    if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to add to cart",
         })
     }
 
     return c.JSON(fiber.Map{
-        "message": "Item added to cart successfully",
+        "message":    "Item added to cart successfully",
+        "cart":       cart,
+        "expires_at": cart.ExpiresAt,
+    })
+}
+
+// addListingToCart holds a resale listing in the buyer's cart. It is bought
+// at checkout together with everything else in the cart.
+func addListingToCart(c *fiber.Ctx, userID, listingID string) error {
+    var cart models.Cart
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var listing models.ResaleListing
+        if err := tx.Where("listing_id = ?", listingID).First(&listing).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Listing not found")
+        }
+
+        if _, err := checkResaleListing(tx, &listing, userID); err != nil {
+            return err
+        }
+
+        if err := holdListing(tx, listing.ListingID); err != nil {
+            if errors.Is(err, ErrNotEnoughTickets) {
+                return fiber.NewError(fiber.StatusConflict, "Listing is no longer available")
+            }
+            return err
+        }
+
+        cart = models.Cart{
+            UserID:           userID,
+            TicketCategoryID: listing.TicketCategoryID,
+            ResaleListingID:  &listing.ListingID,
+            Quantity:         1,
+            ExpiresAt:        time.Now().Add(config.CartHoldDuration()),
+        }
+        return tx.Create(&cart).Error
+    })
+
+    if err != nil {
+        return resaleError(c, err)
+    }
+
+    return c.JSON(fiber.Map{
+        "message":    "Listing added to cart successfully",
+        "cart":       cart,
+        "expires_at": cart.ExpiresAt,
     })
 }
 
@@ -74,54 +291,109 @@ func UpdateCart(c *fiber.Ctx) error {
     }
 
     var cart models.Cart
-    if err := config.DB.Where("user_id = ? AND ticket_category_id = ?", userID, req.TicketCategoryID).First(&cart).Error; err != nil {
+    removed := false
+    expired := false
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        // Resale listings are single tickets and can only be removed
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("user_id = ? AND ticket_category_id = ? AND resale_listing_id IS NULL", userID, req.TicketCategoryID).
+            First(&cart).Error; err != nil {
+            return err
+        }
+
+        if !cart.ExpiresAt.After(time.Now()) {
+            expired = true
+            return removeCartItem(tx, &cart)
+        }
+
+        if req.Quantity <= 0 {
+            // Remove item if quantity is 0 or negative
+            removed = true
+            return removeCartItem(tx, &cart)
+        }
+
+        if diff := req.Quantity - cart.Quantity; diff > 0 {
+            if err := holdTickets(tx, cart.TicketCategoryID, diff); err != nil {
+                return err
+            }
+        } else if diff < 0 {
+            if err := releaseHeldTickets(tx, cart.TicketCategoryID, -diff); err != nil {
+                return err
+            }
+        }
+
+        cart.Quantity = req.Quantity
+        cart.ExpiresAt = time.Now().Add(config.CartHoldDuration())
+        return tx.Save(&cart).Error
+    })
+
+    if errors.Is(err, gorm.ErrRecordNotFound) {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
             "error": "Item not found in cart",
         })
     }
-
-    if req.Quantity <= 0 {
-        // Remove item if quantity is 0 or negative
-        if err := config.DB.Delete(&cart).Error; err != nil {
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "error": "Failed to remove item from cart",
-            })
-        }
-        return c.JSON(fiber.Map{
-            "message": "Item removed from cart",
+    if errors.Is(err, ErrNotEnoughTickets) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Not enough tickets available",
         })
     }
-
-    cart.Quantity = req.Quantity
-    if err := config.DB.Save(&cart).Error; err != nil {
+    if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to update cart",
         })
     }
 
+    if expired {
+        return c.Status(fiber.StatusGone).JSON(fiber.Map{
+            "error": "Cart hold expired, please add the item again",
+        })
+    }
+
+    if removed {
+        return c.JSON(fiber.Map{
+            "message": "Item removed from cart",
+        })
+    }
+
     return c.JSON(fiber.Map{
-        "message": "Cart updated successfully",
+        "message":    "Cart updated successfully",
+        "cart":       cart,
+        "expires_at": cart.ExpiresAt,
     })
 }
 
 func DeleteFromCart(c *fiber.Ctx) error {
     userID := c.Locals("userID").(string)
     ticketCategoryID := c.Query("ticket_category_id")
+    listingID := c.Query("listing_id")
 
-    if ticketCategoryID == "" {
+    if ticketCategoryID == "" && listingID == "" {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-            "error": "Ticket category ID is required",
+            "error": "Ticket category ID or listing ID is required",
         })
     }
 
-    var cart models.Cart
-    if err := config.DB.Where("user_id = ? AND ticket_category_id = ?", userID, ticketCategoryID).First(&cart).Error; err != nil {
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID)
+        if listingID != "" {
+            query = query.Where("resale_listing_id = ?", listingID)
+        } else {
+            query = query.Where("ticket_category_id = ? AND resale_listing_id IS NULL", ticketCategoryID)
+        }
+
+        var cart models.Cart
+        if err := query.First(&cart).Error; err != nil {
+            return err
+        }
+        return removeCartItem(tx, &cart)
+    })
+
+    if errors.Is(err, gorm.ErrRecordNotFound) {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
             "error": "Item not found in cart",
         })
     }
-
-    if err := config.DB.Delete(&cart).Error; err != nil {
+    if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to remove item from cart",
         })
@@ -135,22 +407,41 @@ func DeleteFromCart(c *fiber.Ctx) error {
 func Checkout(c *fiber.Ctx) error {
     userID := c.Locals("userID").(string)
 
-    // Get user's cart items
-    var cartItems []models.Cart
-    if err := config.DB.Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Failed to fetch cart items",
-        })
+    // Attendee details are optional here and can be added to tickets later,
+    // answers to required order questions are not
+    var req CheckoutRequest
+    if len(c.Body()) > 0 {
+        if err := c.BodyParser(&req); err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Invalid request body",
+            })
+        }
     }
 
-    if len(cartItems) == 0 {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-            "error": "Cart is empty",
+    // Holds that ran out are dropped and the rest of the cart is checked out
+    dropped, err := dropExpiredCartItems(userID)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Checkout failed: " + err.Error(),
         })
     }
 
+    var order *models.Order
+    var items []models.OrderItem
+
     // Process checkout in transaction
-    err := config.DB.Transaction(func(tx *gorm.DB) error {
+    err = config.DB.Transaction(func(tx *gorm.DB) error {
+        // Get user's cart items
+        var cartItems []models.Cart
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+            return err
+        }
+
+        if len(cartItems) == 0 && len(dropped) == 0 {
+            return fiber.NewError(fiber.StatusBadRequest, "Cart is empty")
+        }
+
+        var lines []orderLine
         for _, item := range cartItems {
             // Get ticket category details
             var ticketCategory models.TicketCategory
@@ -158,28 +449,76 @@ func Checkout(c *fiber.Ctx) error {
                 return err
             }
 
-            // Check quota
-            if ticketCategory.Sold + item.Quantity > ticketCategory.Quota {
-                return fiber.NewError(fiber.StatusBadRequest, "Not enough tickets available for category: " + ticketCategory.Description)
+            // A hold can still run out between dropping expired rows and here
+            if !item.ExpiresAt.After(time.Now()) {
+                if _, err := removeExpiredCartItem(tx, &item); err != nil {
+                    return err
+                }
+                dropped = append(dropped, item)
+                continue
             }
 
-            // Create tickets
-            for i := 0; i < item.Quantity; i++ {
-                ticket := models.Ticket{
-                    EventID:          ticketCategory.EventID,
-                    TicketCategoryID: item.TicketCategoryID,
-                    OwnerID:          userID,
-                    Code:             uuid.New().String(),
+            // Resale tickets are checked against the resale rules instead and
+            // are tied to the order once it exists
+            if item.ResaleListingID != nil {
+                var listing models.ResaleListing
+                if err := tx.Where("listing_id = ? AND status = ?", *item.ResaleListingID, "held").First(&listing).Error; err != nil {
+                    return fiber.NewError(fiber.StatusConflict, "Resale ticket is no longer available for category: " + ticketCategory.Description)
                 }
-                if err := tx.Create(&ticket).Error; err != nil {
+                if _, err := checkResaleListing(tx, &listing, userID); err != nil {
                     return err
                 }
+                lines = append(lines, orderLine{TicketCategory: ticketCategory, Listing: &listing})
+                continue
             }
 
-            // Update sold count
-            if err := tx.Model(&models.TicketCategory{}).Where("ticket_category_id = ?", item.TicketCategoryID).Update("sold", ticketCategory.Sold + item.Quantity).Error; err != nil {
+            if ticketCategory.Status == "retired" {
+                return fiber.NewError(fiber.StatusBadRequest, "Ticket category is no longer on sale: " + ticketCategory.Description)
+            }
+
+            if !eventOnSale(tx, ticketCategory.EventID) {
+                return fiber.NewError(fiber.StatusBadRequest, "Event is not on sale for category: " + ticketCategory.Description)
+            }
+
+            // Turn the cart hold into sold tickets
+            if err := sellHeldTickets(tx, item.TicketCategoryID, item.Quantity); err != nil {
+                if errors.Is(err, ErrNotEnoughTickets) {
+                    return fiber.NewError(fiber.StatusBadRequest, "Not enough tickets available for category: " + ticketCategory.Description)
+                }
                 return err
             }
+
+            lines = append(lines, orderLine{TicketCategory: ticketCategory, Quantity: item.Quantity})
+        }
+
+        if len(lines) == 0 {
+            return fiber.NewError(fiber.StatusGone, "Cart holds expired, please add the items again")
+        }
+
+        var err error
+        order, items, err = createOrder(tx, userID, lines)
+        if err != nil {
+            return err
+        }
+
+        for _, item := range items {
+            if item.ResaleListingID == nil {
+                continue
+            }
+            if err := sellHeldListing(tx, *item.ResaleListingID, order.OrderID); err != nil {
+                if errors.Is(err, ErrNotEnoughTickets) {
+                    return fiber.NewError(fiber.StatusConflict, "Resale ticket is no longer available")
+                }
+                return err
+            }
+        }
+
+        if err := saveOrderAttendees(tx, items, req.Attendees); err != nil {
+            return err
+        }
+
+        if err := saveOrderAnswers(tx, order.OrderID, items, req.Answers); err != nil {
+            return err
         }
 
         // Clear cart
@@ -191,12 +530,136 @@ func Checkout(c *fiber.Ctx) error {
     })
 
     if err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+        status := fiber.StatusInternalServerError
+        var fiberErr *fiber.Error
+        if errors.As(err, &fiberErr) {
+            status = fiberErr.Code
+        }
+        return c.Status(status).JSON(fiber.Map{
             "error": "Checkout failed: " + err.Error(),
         })
     }
 
+    // Tickets are minted once the payment provider confirms the charge. If no
+    // charge can be created the buyer keeps the cart and can try again.
+    payment, err := startPayment(order)
+    if err != nil {
+        if restoreErr := restoreCart(order, items); restoreErr != nil {
+            log.Println("Failed to restore cart for order", order.OrderID+":", restoreErr)
+            cancelUnchargedOrder(order.OrderID)
+        }
+        return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+            "error": "Failed to start payment",
+        })
+    }
+
     return c.JSON(fiber.Map{
-        "message": "Checkout successful",
+        "message":       "Checkout successful, awaiting payment",
+        "order":         order,
+        "items":         items,
+        "payment":       payment,
+        "dropped_items": dropped,
     })
-}
\ No newline at end of file
+}
+
+// ReleaseExpiredCartHolds deletes cart rows whose hold window has passed and
+// returns their tickets to the category quota or their listing to the market.
+func ReleaseExpiredCartHolds() (int, error) {
+    var expired []models.Cart
+    if err := config.DB.Where("expires_at <= ?", time.Now()).Find(&expired).Error; err != nil {
+        return 0, err
+    }
+
+    released := 0
+    for i := range expired {
+        cart := expired[i]
+        deleted := false
+        err := config.DB.Transaction(func(tx *gorm.DB) error {
+            var err error
+            deleted, err = removeExpiredCartItem(tx, &cart)
+            return err
+        })
+        if err != nil {
+            log.Println("Failed to release cart hold", cart.CartID+":", err)
+            continue
+        }
+        if deleted {
+            released++
+        }
+    }
+
+    return released, nil
+}
+
+// MigrateLegacyCarts prepares carts from before the unique item index: rows
+// holding the same category twice are merged into the oldest one and resale
+// rows get their listing as item key. Runs before the index is created.
+func MigrateLegacyCarts() (int, error) {
+    migrator := config.DB.Migrator()
+    hasListings := migrator.HasColumn(&models.Cart{}, "ResaleListingID")
+
+    type duplicate struct {
+        UserID           string
+        TicketCategoryID string
+    }
+    query := config.DB.Model(&models.Cart{}).
+        Select("user_id, ticket_category_id").
+        Group("user_id, ticket_category_id").
+        Having("COUNT(*) > 1")
+    if hasListings {
+        query = query.Where("resale_listing_id IS NULL")
+    }
+    var duplicates []duplicate
+    if err := query.Scan(&duplicates).Error; err != nil {
+        return 0, err
+    }
+
+    merged := 0
+    for _, dup := range duplicates {
+        err := config.DB.Transaction(func(tx *gorm.DB) error {
+            rows := tx.Where("user_id = ? AND ticket_category_id = ?", dup.UserID, dup.TicketCategoryID)
+            if hasListings {
+                rows = rows.Where("resale_listing_id IS NULL")
+            }
+            var carts []models.Cart
+            if err := rows.Order("created_at").Find(&carts).Error; err != nil {
+                return err
+            }
+            if len(carts) < 2 {
+                return nil
+            }
+
+            // The holds stay as they are, only the rows are combined
+            keep := carts[0]
+            for _, cart := range carts[1:] {
+                keep.Quantity += cart.Quantity
+                if cart.ExpiresAt.After(keep.ExpiresAt) {
+                    keep.ExpiresAt = cart.ExpiresAt
+                }
+                if err := tx.Where("cart_id = ?", cart.CartID).Delete(&models.Cart{}).Error; err != nil {
+                    return err
+                }
+            }
+            return tx.Model(&models.Cart{}).Where("cart_id = ?", keep.CartID).Updates(map[string]interface{}{
+                "quantity":   keep.Quantity,
+                "expires_at": keep.ExpiresAt,
+            }).Error
+        })
+        if err != nil {
+            return merged, err
+        }
+        merged++
+    }
+
+    if err := migrator.AddColumn(&models.Cart{}, "ItemKey"); err != nil {
+        return merged, err
+    }
+    if hasListings {
+        if err := config.DB.Model(&models.Cart{}).Where("resale_listing_id IS NOT NULL").
+            Update("item_key", gorm.Expr("resale_listing_id")).Error; err != nil {
+            return merged, err
+        }
+    }
+
+    return merged, nil
+}
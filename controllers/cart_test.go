@@ -0,0 +1,108 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// TestConcurrentAddToCartKeepsOneRow adds the same category from parallel
+// requests, which must end up as a single cart row holding every ticket.
+func TestConcurrentAddToCartKeepsOneRow(t *testing.T) {
+    openTestDB(t)
+    app := newPaymentTestApp()
+
+    const adds = 20
+    category := seedOnSaleCategory(t, 100, 50)
+    buyer := seedUser(t, "user")
+    body, _ := json.Marshal(AddToCartRequest{TicketCategoryID: category.TicketCategoryID, Quantity: 1})
+
+    var wg sync.WaitGroup
+    statuses := make(chan int, adds)
+    for i := 0; i < adds; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            statuses <- doJSON(t, app, http.MethodPost, "/api/cart", buyer.UserID, nil, body, nil)
+        }()
+    }
+    wg.Wait()
+    close(statuses)
+
+    ok := 0
+    for status := range statuses {
+        if status == fiber.StatusOK {
+            ok++
+        }
+    }
+
+    var carts []models.Cart
+    config.DB.Where("user_id = ?", buyer.UserID).Find(&carts)
+    if len(carts) != 1 {
+        t.Fatalf("%d cart rows, want 1", len(carts))
+    }
+    if carts[0].Quantity != ok {
+        t.Errorf("cart holds %d tickets after %d successful adds", carts[0].Quantity, ok)
+    }
+
+    var after models.TicketCategory
+    config.DB.Where("ticket_category_id = ?", category.TicketCategoryID).First(&after)
+    if after.Held != carts[0].Quantity {
+        t.Errorf("category holds %d tickets, cart %d", after.Held, carts[0].Quantity)
+    }
+}
+
+// TestCheckoutDropsExpiredHolds checks out a cart with one live and one
+// expired row: the expired one is given back and reported, the other bought.
+func TestCheckoutDropsExpiredHolds(t *testing.T) {
+    openTestDB(t)
+    utils.Payment = utils.NewMockPaymentProvider([]byte("webhook-secret"))
+    app := newPaymentTestApp()
+
+    live := seedOnSaleCategory(t, 10, 100)
+    stale := seedOnSaleCategory(t, 10, 100)
+    buyer := seedUser(t, "user")
+
+    for _, category := range []models.TicketCategory{live, stale} {
+        body, _ := json.Marshal(AddToCartRequest{TicketCategoryID: category.TicketCategoryID, Quantity: 2})
+        if status := doJSON(t, app, http.MethodPost, "/api/cart", buyer.UserID, nil, body, nil); status != fiber.StatusOK {
+            t.Fatalf("add to cart: status %d", status)
+        }
+    }
+    config.DB.Model(&models.Cart{}).
+        Where("user_id = ? AND ticket_category_id = ?", buyer.UserID, stale.TicketCategoryID).
+        Update("expires_at", time.Now().Add(-time.Minute))
+
+    var checkout struct {
+        Order        models.Order  `json:"order"`
+        DroppedItems []models.Cart `json:"dropped_items"`
+    }
+    if status := doJSON(t, app, http.MethodPost, "/api/checkout", buyer.UserID, nil, nil, &checkout); status != fiber.StatusOK {
+        t.Fatalf("checkout: status %d", status)
+    }
+    if checkout.Order.TotalAmount != 200 {
+        t.Errorf("order total = %.2f, want 200", checkout.Order.TotalAmount)
+    }
+    if len(checkout.DroppedItems) != 1 || checkout.DroppedItems[0].TicketCategoryID != stale.TicketCategoryID {
+        t.Errorf("dropped items = %+v, want the expired row", checkout.DroppedItems)
+    }
+
+    var after models.TicketCategory
+    config.DB.Where("ticket_category_id = ?", stale.TicketCategoryID).First(&after)
+    if after.Held != 0 || after.Sold != 0 {
+        t.Errorf("expired category sold %d, held %d, want nothing", after.Sold, after.Held)
+    }
+
+    var left int64
+    config.DB.Model(&models.Cart{}).Where("user_id = ?", buyer.UserID).Count(&left)
+    if left != 0 {
+        t.Errorf("%d cart rows left after checkout", left)
+    }
+}
@@ -0,0 +1,393 @@
+package controllers
+
+import (
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/google/uuid"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+type CheckInRequest struct {
+    Code      string `json:"code"`
+    Gate      string `json:"gate"`
+    Device    string `json:"device"`
+    Direction string `json:"direction"`
+}
+
+// scan describes who scanned what, where and when, and whether the holder is
+// coming in or going out. Offline scans carry the device's own scan ID so a
+// re-uploaded batch is not applied twice. CheckInID is the row the scan is
+// recorded as.
+type scan struct {
+    CheckInID    string
+    Code         string
+    ScannedBy    string
+    Gate         string
+    Device       string
+    DeviceScanID *string
+    Offline      bool
+    Direction    string
+    ScannedAt    time.Time
+}
+
+// scanTicket validates a scanned code for the event, applies the ticket's
+// admission policy and records the scan with its outcome: admitted,
+// checked_out, duplicate, not_inside, wrong_event, cancelled or invalid.
+func scanTicket(event *models.Event, s scan) (*models.CheckIn, *models.Ticket, error) {
+    code := strings.TrimSpace(s.Code)
+    if len(code) > 255 {
+        code = code[:255]
+    }
+
+    if s.Direction != "out" {
+        s.Direction = "in"
+    }
+
+    s.CheckInID = uuid.New().String()
+    checkIn := models.CheckIn{
+        CheckInID:    s.CheckInID,
+        EventID:      event.EventID,
+        Code:         code,
+        ScannedBy:    s.ScannedBy,
+        Gate:         s.Gate,
+        Direction:    s.Direction,
+        Device:       s.Device,
+        DeviceScanID: s.DeviceScanID,
+        Source:       "online",
+        ScannedAt:    s.ScannedAt,
+    }
+    if s.Offline {
+        checkIn.Source = "offline"
+    }
+
+    var ticket *models.Ticket
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var outcome, message string
+        var err error
+        ticket, outcome, message, err = admitTicket(tx, event, code, s)
+        if err != nil {
+            return err
+        }
+        checkIn.Outcome = outcome
+        checkIn.Message = message
+        if ticket != nil {
+            checkIn.TicketID = &ticket.TicketID
+            checkIn.TicketCategoryID = &ticket.TicketCategoryID
+        }
+
+        // Recorded in the same transaction so the next scan of this ticket
+        // sees it in the history
+        return tx.Create(&checkIn).Error
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+
+    checkInEvents.publish(checkIn)
+
+    return &checkIn, ticket, nil
+}
+
+// admitTicket decides the outcome of a scan and updates the ticket when it is
+// admitted. Database failures are returned, so the scan is not recorded.
+func admitTicket(tx *gorm.DB, event *models.Event, code string, s scan) (*models.Ticket, string, string, error) {
+    payload, err := utils.VerifyTicketCode(code)
+    if err != nil {
+        return nil, "invalid", "Ticket code is not valid", nil
+    }
+
+    // Lock the ticket so concurrent scans of it are decided one at a time
+    var ticket models.Ticket
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("ticket_id = ?", payload.TicketID).First(&ticket).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, "invalid", "Ticket does not exist", nil
+        }
+        return nil, "", "", err
+    }
+
+    if ticket.Code != code {
+        return &ticket, "invalid", "Ticket code was reissued, this copy is no longer valid", nil
+    }
+
+    if ticket.EventID != event.EventID {
+        var other models.Event
+        if tx.Where("event_id = ?", ticket.EventID).First(&other).Error == nil {
+            return &ticket, "wrong_event", "Ticket is for another event: " + other.Name, nil
+        }
+        return &ticket, "wrong_event", "Ticket is for another event", nil
+    }
+
+    if ticket.Status == "used" {
+        if s.Offline && s.Direction != "out" {
+            superseded, err := supersedeLaterAdmission(tx, &ticket, s, time.Time{}, time.Time{})
+            if err != nil {
+                return nil, "", "", err
+            }
+            if superseded {
+                return &ticket, "admitted", "Ticket admitted", nil
+            }
+        }
+        return &ticket, "duplicate", duplicateMessage(tx, &ticket, "Ticket already checked in"), nil
+    }
+
+    if ticket.Status != "active" {
+        return &ticket, "cancelled", "Ticket is " + ticket.Status + " and cannot be admitted", nil
+    }
+
+    var ticketCategory models.TicketCategory
+    if err := tx.Where("ticket_category_id = ?", ticket.TicketCategoryID).First(&ticketCategory).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return &ticket, "invalid", "Ticket category does not exist", nil
+        }
+        return nil, "", "", err
+    }
+
+    var last models.CheckIn
+    hasLast := tx.Where("ticket_id = ? AND outcome IN ?", ticket.TicketID, []string{"admitted", "checked_out"}).
+        Order("scanned_at DESC").First(&last).Error == nil
+    inside := hasLast && last.Outcome == "admitted"
+
+    if s.Direction == "out" {
+        if ticketCategory.AdmissionPolicy == "single" {
+            return &ticket, "invalid", "Tickets in this category do not allow re-entry, there is nothing to check out", nil
+        }
+        if !inside {
+            return &ticket, "not_inside", "Ticket holder is not inside", nil
+        }
+        return &ticket, "checked_out", "Ticket holder checked out", nil
+    }
+
+    var admissions int64
+    tx.Model(&models.CheckIn{}).Where("ticket_id = ? AND outcome = ?", ticket.TicketID, "admitted").Count(&admissions)
+
+    // The last allowed entry uses the ticket up
+    finalEntry := false
+    if ticketCategory.AdmissionPolicy != "single" && ticketCategory.MaxEntries > 0 {
+        if int(admissions) >= ticketCategory.MaxEntries {
+            return &ticket, "duplicate", "All entries on this ticket have been used", nil
+        }
+        finalEntry = int(admissions)+1 >= ticketCategory.MaxEntries
+    }
+
+    switch ticketCategory.AdmissionPolicy {
+    case "reentry":
+        if inside {
+            return &ticket, "duplicate", duplicateMessage(tx, &ticket, "Ticket holder is already inside"), nil
+        }
+    case "multi":
+        // Only limited by max_entries
+    case "per_day":
+        year, month, day := s.ScannedAt.Date()
+        dayStart := time.Date(year, month, day, 0, 0, 0, 0, s.ScannedAt.Location())
+        var today int64
+        tx.Model(&models.CheckIn{}).
+            Where("ticket_id = ? AND outcome = ? AND scanned_at >= ? AND scanned_at < ?", ticket.TicketID, "admitted", dayStart, dayStart.AddDate(0, 0, 1)).
+            Count(&today)
+        if today > 0 {
+            if s.Offline {
+                superseded, err := supersedeLaterAdmission(tx, &ticket, s, dayStart, dayStart.AddDate(0, 0, 1))
+                if err != nil {
+                    return nil, "", "", err
+                }
+                if superseded {
+                    return &ticket, "admitted", "Ticket admitted", nil
+                }
+            }
+            return &ticket, "duplicate", "Ticket was already used today", nil
+        }
+    default:
+        finalEntry = true
+    }
+
+    updates := map[string]interface{}{}
+    if admissions == 0 {
+        updates["checked_in_by"] = s.ScannedBy
+        updates["checked_in_at"] = s.ScannedAt
+    }
+    if finalEntry {
+        updates["status"] = "used"
+    }
+    if len(updates) > 0 {
+        if err := tx.Model(&ticket).Updates(updates).Error; err != nil {
+            return nil, "", "", err
+        }
+    }
+
+    return &ticket, "admitted", "Ticket admitted", nil
+}
+
+// supersedeLaterAdmission handles an offline scan uploaded after a scan that
+// happened later in time but was synced first. The earliest scan wins, so the
+// latest admission after it (within [from, to) when given) is turned into the
+// duplicate and points at the scan that replaced it. Reports whether there was
+// such an admission.
+func supersedeLaterAdmission(tx *gorm.DB, ticket *models.Ticket, s scan, from, to time.Time) (bool, error) {
+    query := tx.Where("ticket_id = ? AND outcome = ? AND scanned_at > ?", ticket.TicketID, "admitted", s.ScannedAt)
+    if !from.IsZero() {
+        query = query.Where("scanned_at >= ? AND scanned_at < ?", from, to)
+    }
+
+    var later models.CheckIn
+    if err := query.Order("scanned_at DESC").First(&later).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return false, nil
+        }
+        return false, err
+    }
+
+    message := "Ticket already checked in at " + s.ScannedAt.Format("15:04:05")
+    if s.Gate != "" {
+        message += " at gate " + s.Gate
+    }
+    if err := tx.Model(&later).Updates(map[string]interface{}{
+        "outcome":       "duplicate",
+        "message":       message,
+        "superseded_by": s.CheckInID,
+    }).Error; err != nil {
+        return false, err
+    }
+
+    // The ticket remembers who let the holder in first
+    if ticket.CheckedInAt == nil || s.ScannedAt.Before(*ticket.CheckedInAt) {
+        if err := tx.Model(ticket).Updates(map[string]interface{}{
+            "checked_in_by": s.ScannedBy,
+            "checked_in_at": s.ScannedAt,
+        }).Error; err != nil {
+            return false, err
+        }
+    }
+    return true, nil
+}
+
+func duplicateMessage(tx *gorm.DB, ticket *models.Ticket, fallback string) string {
+    var previous models.CheckIn
+    if tx.Where("ticket_id = ? AND outcome = ?", ticket.TicketID, "admitted").Order("scanned_at DESC").First(&previous).Error != nil {
+        return fallback
+    }
+
+    message := fallback + " at " + previous.ScannedAt.Format("15:04:05")
+    if previous.Gate != "" {
+        message += " at gate " + previous.Gate
+    }
+    return message
+}
+
+// checkInStatus maps a scan outcome to the HTTP status sent to the scanner.
+func checkInStatus(outcome string) int {
+    switch outcome {
+    case "admitted", "checked_out":
+        return fiber.StatusOK
+    case "duplicate":
+        return fiber.StatusConflict
+    default:
+        return fiber.StatusUnprocessableEntity
+    }
+}
+
+func checkInResponse(checkIn *models.CheckIn, ticket *models.Ticket) fiber.Map {
+    response := fiber.Map{
+        "admitted":  checkIn.Outcome == "admitted",
+        "direction": checkIn.Direction,
+        "outcome":   checkIn.Outcome,
+        "message":   checkIn.Message,
+        "check_in":  checkIn,
+    }
+    if ticket != nil {
+        response["ticket"] = ticket
+        attendeeDetails(response, ticket)
+    }
+    return response
+}
+
+func CheckInByCode(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can check in tickets",
+        })
+    }
+
+    var req CheckInRequest
+    if err := c.BodyParser(&req); err != nil || req.Code == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Code is required",
+        })
+    }
+
+    checkIn, ticket, err := scanTicket(&event, scan{
+        Code:      req.Code,
+        ScannedBy: userID,
+        Gate:      req.Gate,
+        Device:    req.Device,
+        Direction: req.Direction,
+        ScannedAt: time.Now(),
+    })
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to record check-in",
+        })
+    }
+
+    return c.Status(checkInStatus(checkIn.Outcome)).JSON(checkInResponse(checkIn, ticket))
+}
+
+func GetCheckIns(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can view check-ins",
+        })
+    }
+
+    query := config.DB.Where("event_id = ?", event.EventID)
+    if outcome := c.Query("outcome"); outcome != "" {
+        query = query.Where("outcome = ?", outcome)
+    }
+    if gate := c.Query("gate"); gate != "" {
+        query = query.Where("gate = ?", gate)
+    }
+    if ticketID := c.Query("ticket_id"); ticketID != "" {
+        query = query.Where("ticket_id = ?", ticketID)
+    }
+
+    limit := c.QueryInt("limit", 100)
+    if limit <= 0 || limit > 1000 {
+        limit = 100
+    }
+
+    var checkIns []models.CheckIn
+    if err := query.Order("scanned_at DESC").Limit(limit).Find(&checkIns).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch check-ins",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "check_ins": checkIns,
+    })
+}
@@ -0,0 +1,336 @@
+package controllers
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// checkInHub fans out recorded scans to dashboards watching the same event.
+// It lives in process memory, so every API instance only sees its own scans.
+type checkInHub struct {
+    mu          sync.Mutex
+    subscribers map[string]map[chan models.CheckIn]struct{}
+}
+
+var checkInEvents = &checkInHub{
+    subscribers: map[string]map[chan models.CheckIn]struct{}{},
+}
+
+func (h *checkInHub) subscribe(eventID string) chan models.CheckIn {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    ch := make(chan models.CheckIn, 64)
+    if h.subscribers[eventID] == nil {
+        h.subscribers[eventID] = map[chan models.CheckIn]struct{}{}
+    }
+    h.subscribers[eventID][ch] = struct{}{}
+    return ch
+}
+
+func (h *checkInHub) unsubscribe(eventID string, ch chan models.CheckIn) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    delete(h.subscribers[eventID], ch)
+    if len(h.subscribers[eventID]) == 0 {
+        delete(h.subscribers, eventID)
+    }
+}
+
+func (h *checkInHub) publish(checkIn models.CheckIn) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    for ch := range h.subscribers[checkIn.EventID] {
+        // A dashboard that cannot keep up misses scans but still gets stats
+        select {
+        case ch <- checkIn:
+        default:
+        }
+    }
+}
+
+type checkInCount struct {
+    Key   string `json:"key"`
+    Name  string `json:"name,omitempty"`
+    Count int64  `json:"count"`
+}
+
+type scanRate struct {
+    Minute     time.Time `json:"minute"`
+    Scans      int       `json:"scans"`
+    Admitted   int       `json:"admitted"`
+    CheckedOut int       `json:"checked_out"`
+    Rejected   int       `json:"rejected"`
+}
+
+// scanRejected reports whether a scan turned the holder away. Check-outs are
+// regular scans and never raise an alert.
+func scanRejected(outcome string) bool {
+    return outcome != "admitted" && outcome != "checked_out"
+}
+
+// checkInStats summarises attendance for an event: admitted tickets per
+// category, admissions per gate, outcomes, and scans per minute over the last
+// 15 minutes. Re-entries count once per category but every time at a gate.
+func checkInStats(event *models.Event) (fiber.Map, error) {
+    var ticketsSold int64
+    if err := config.DB.Model(&models.Ticket{}).Where("event_id = ?", event.EventID).Count(&ticketsSold).Error; err != nil {
+        return nil, err
+    }
+
+    var byCategory []checkInCount
+    if err := config.DB.Table("check_ins").
+        Select("check_ins.ticket_category_id AS `key`, ticket_categories.name AS name, COUNT(DISTINCT check_ins.ticket_id) AS count").
+        Joins("LEFT JOIN ticket_categories ON ticket_categories.ticket_category_id = check_ins.ticket_category_id").
+        Where("check_ins.event_id = ? AND check_ins.outcome = ?", event.EventID, "admitted").
+        Group("check_ins.ticket_category_id, ticket_categories.name").
+        Scan(&byCategory).Error; err != nil {
+        return nil, err
+    }
+
+    var byGate []checkInCount
+    if err := config.DB.Table("check_ins").
+        Select("gate AS `key`, COUNT(*) AS count").
+        Where("event_id = ? AND outcome = ?", event.EventID, "admitted").
+        Group("gate").
+        Scan(&byGate).Error; err != nil {
+        return nil, err
+    }
+
+    var byOutcome []checkInCount
+    if err := config.DB.Table("check_ins").
+        Select("outcome AS `key`, COUNT(*) AS count").
+        Where("event_id = ?", event.EventID).
+        Group("outcome").
+        Scan(&byOutcome).Error; err != nil {
+        return nil, err
+    }
+
+    now := time.Now().Truncate(time.Minute)
+    since := now.Add(-14 * time.Minute)
+
+    var recent []models.CheckIn
+    if err := config.DB.Select("outcome", "scanned_at").
+        Where("event_id = ? AND scanned_at >= ?", event.EventID, since).
+        Find(&recent).Error; err != nil {
+        return nil, err
+    }
+
+    rates := make([]scanRate, 15)
+    for i := range rates {
+        rates[i].Minute = since.Add(time.Duration(i) * time.Minute)
+    }
+    for _, checkIn := range recent {
+        i := int(checkIn.ScannedAt.Sub(since) / time.Minute)
+        if i < 0 || i >= len(rates) {
+            continue
+        }
+        rates[i].Scans++
+        switch checkIn.Outcome {
+        case "admitted":
+            rates[i].Admitted++
+        case "checked_out":
+            rates[i].CheckedOut++
+        default:
+            rates[i].Rejected++
+        }
+    }
+
+    var admitted int64
+    for _, count := range byCategory {
+        admitted += count.Count
+    }
+
+    // Holders whose latest in/out scan let them in
+    var inside int64
+    if err := config.DB.Raw(`SELECT COUNT(*) FROM check_ins c
+        WHERE c.event_id = ? AND c.outcome = 'admitted'
+        AND NOT EXISTS (SELECT 1 FROM check_ins n WHERE n.ticket_id = c.ticket_id
+            AND n.outcome IN ('admitted', 'checked_out') AND n.scanned_at > c.scanned_at)`, event.EventID).
+        Scan(&inside).Error; err != nil {
+        return nil, err
+    }
+
+    return fiber.Map{
+        "event_id":             event.EventID,
+        "generated_at":         time.Now(),
+        "tickets_sold":         ticketsSold,
+        "admitted":             admitted,
+        "inside":               inside,
+        "admitted_by_category": byCategory,
+        "admitted_by_gate":     byGate,
+        "scans_by_outcome":     byOutcome,
+        "scans_per_minute":     rates,
+    }, nil
+}
+
+func writeServerEvent(w *bufio.Writer, event string, data interface{}) error {
+    payload, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+    if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+        return err
+    }
+    return w.Flush()
+}
+
+func GetCheckInStats(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can view check-ins",
+        })
+    }
+
+    stats, err := checkInStats(&event)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to compute check-in stats",
+        })
+    }
+
+    return c.JSON(stats)
+}
+
+// CreateStreamToken hands a dashboard a short-lived token for opening the
+// check-in stream with EventSource, which cannot send the access token.
+func CreateStreamToken(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+    sessionID := c.Locals("sessionID").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can view check-ins",
+        })
+    }
+
+    token, expiresAt, err := utils.GenerateStreamToken(userID, role, sessionID, event.EventID)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to create stream token",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "token":      token,
+        "expires_at": expiresAt,
+        "stream_url": "/api/events/" + event.EventID + "/checkins/stream?token=" + token,
+    })
+}
+
+// StreamCheckIns pushes Server-Sent Events to a live dashboard: "stats" with
+// the current counts, "scan" for each scan and "alert" for rejected scans.
+func StreamCheckIns(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can view check-ins",
+        })
+    }
+
+    c.Set(fiber.HeaderContentType, "text/event-stream")
+    c.Set(fiber.HeaderCacheControl, "no-cache")
+    c.Set(fiber.HeaderConnection, "keep-alive")
+    c.Set("X-Accel-Buffering", "no")
+
+    scans := checkInEvents.subscribe(event.EventID)
+
+    c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+        defer checkInEvents.unsubscribe(event.EventID, scans)
+
+        sendStats := func() error {
+            stats, err := checkInStats(&event)
+            if err != nil {
+                return writeServerEvent(w, "error", fiber.Map{"error": "Failed to compute check-in stats"})
+            }
+            return writeServerEvent(w, "stats", stats)
+        }
+
+        if err := sendStats(); err != nil {
+            return
+        }
+
+        // Stats are recomputed at most once a second however busy the gates are
+        refresh := time.NewTicker(time.Second)
+        defer refresh.Stop()
+        heartbeat := time.NewTicker(15 * time.Second)
+        defer heartbeat.Stop()
+
+        dirty := false
+        for {
+            select {
+            case checkIn := <-scans:
+                if err := writeServerEvent(w, "scan", checkIn); err != nil {
+                    return
+                }
+                if scanRejected(checkIn.Outcome) {
+                    if err := writeServerEvent(w, "alert", fiber.Map{
+                        "outcome":    checkIn.Outcome,
+                        "message":    checkIn.Message,
+                        "gate":       checkIn.Gate,
+                        "device":     checkIn.Device,
+                        "ticket_id":  checkIn.TicketID,
+                        "scanned_at": checkIn.ScannedAt,
+                    }); err != nil {
+                        return
+                    }
+                }
+                dirty = true
+            case <-refresh.C:
+                if dirty {
+                    if err := sendStats(); err != nil {
+                        return
+                    }
+                    dirty = false
+                }
+            case <-heartbeat.C:
+                // Keeps the per-minute rates moving on a quiet gate, stops
+                // proxies from closing an idle stream and tells us when the
+                // client has gone away
+                if err := sendStats(); err != nil {
+                    return
+                }
+                dirty = false
+            }
+        }
+    })
+
+    return nil
+}
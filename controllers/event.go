@@ -1,21 +1,27 @@
 package controllers
 
 import (
+    "errors"
+
     "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
     "ticketing-backend/config"
     "ticketing-backend/models"
     "time"
 )
 
 type CreateEventRequest struct {
-    Name        string    `json:"name"`
-    DateStart   time.Time `json:"date_start"`
-    DateEnd     time.Time `json:"date_end"`
-    Location    string    `json:"location"`
-    Description string    `json:"description"`
-    Image       *string   `json:"image"`
-    Flyer       *string   `json:"flyer"`
-    Category    string    `json:"category"`
+    Name             string    `json:"name"`
+    DateStart        time.Time `json:"date_start"`
+    DateEnd          time.Time `json:"date_end"`
+    Location         string    `json:"location"`
+    Description      string    `json:"description"`
+    Image            *string   `json:"image"`
+    Flyer            *string   `json:"flyer"`
+    Category         string    `json:"category"`
+    TransferDisabled *bool     `json:"transfer_disabled"`
+    ResaleEnabled    *bool     `json:"resale_enabled"`
+    ResaleMaxMarkup  *float64  `json:"resale_max_markup"`
 }
 
 func CreateEvent(c *fiber.Ctx) error {
@@ -35,6 +41,13 @@ func CreateEvent(c *fiber.Ctx) error {
         })
     }
 
+    // Markup is a percentage on top of the category price
+    if req.ResaleMaxMarkup != nil && *req.ResaleMaxMarkup < 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Resale markup cannot be negative",
+        })
+    }
+
     event := models.Event{
         OwnerID:     userID,
         Name:        req.Name,
@@ -45,7 +58,16 @@ func CreateEvent(c *fiber.Ctx) error {
         Image:       req.Image,
         Flyer:       req.Flyer,
         Category:    req.Category,
-        Status:      "pending",
+        Status:      "draft",
+    }
+    if req.TransferDisabled != nil {
+        event.TransferDisabled = *req.TransferDisabled
+    }
+    if req.ResaleEnabled != nil {
+        event.ResaleEnabled = *req.ResaleEnabled
+    }
+    if req.ResaleMaxMarkup != nil {
+        event.ResaleMaxMarkup = *req.ResaleMaxMarkup
     }
 
     if err := config.DB.Create(&event).Error; err != nil {
@@ -60,24 +82,12 @@ func CreateEvent(c *fiber.Ctx) error {
     })
 }
 
-func GetEvents(c *fiber.Ctx) error {
-    var events []models.Event
-    if err := config.DB.Preload("Owner").Where("status = ?", "approved").Find(&events).Error; err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Failed to fetch events",
-        })
-    }
-
-    return c.JSON(fiber.Map{
-        "events": events,
-    })
-}
-
 func GetEvent(c *fiber.Ctx) error {
     eventID := c.Params("id")
 
+    // Events under review are only visible to their owner and admins
     var event models.Event
-    if err := config.DB.Preload("Owner").Where("event_id = ?", eventID).First(&event).Error; err != nil {
+    if err := config.DB.Where("event_id = ? AND status IN ?", eventID, publicEventStatuses).First(&event).Error; err != nil {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
             "error": "Event not found",
         })
@@ -105,6 +115,12 @@ func UpdateEvent(c *fiber.Ctx) error {
         })
     }
 
+    if event.Status == "cancelled" || event.Status == "completed" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Event is " + event.Status + " and can no longer be edited",
+        })
+    }
+
     var req CreateEventRequest
     if err := c.BodyParser(&req); err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -112,44 +128,113 @@ func UpdateEvent(c *fiber.Ctx) error {
         })
     }
 
-    if err := config.DB.Model(&event).Updates(models.Event{
-        Name:        req.Name,
-        DateStart:   req.DateStart,
-        DateEnd:     req.DateEnd,
-        Location:    req.Location,
-        Description: req.Description,
-        Image:       req.Image,
-        Flyer:       req.Flyer,
-        Category:    req.Category,
-    }).Error; err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Failed to update event",
+    // Markup is a percentage on top of the category price
+    if req.ResaleMaxMarkup != nil && *req.ResaleMaxMarkup < 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Resale markup cannot be negative",
         })
     }
 
-    return c.JSON(fiber.Map{
-        "message": "Event updated successfully",
+    // Edits to the reviewed details of an approved event need a new review
+    resubmit := (event.Status == "approved" || event.Status == "published") && eventKeyDetailsChanged(&event, &req)
+
+    // Date and venue changes are announced to holders once saved
+    previous := event
+    var reschedule *models.EventReschedule
+    var revision *models.EventRevision
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        // A published event with sales stays public, so holders can still
+        // look it up, transfer and resell. Its key details change once an
+        // admin approved them.
+        if resubmit && event.Status == "published" && eventHasSales(tx, event.EventID) {
+            resubmit = false
+            var err error
+            revision, err = stageEventRevision(tx, &event, &req)
+            if err != nil {
+                return err
+            }
+            req.Name, req.Location, req.Description, req.Category = "", "", "", ""
+            req.DateStart, req.DateEnd = time.Time{}, time.Time{}
+        }
+
+        var err error
+        reschedule, err = recordReschedule(tx, &previous, &req)
+        if err != nil {
+            return err
+        }
+
+        if err := tx.Model(&event).Updates(models.Event{
+            Name:        req.Name,
+            DateStart:   req.DateStart,
+            DateEnd:     req.DateEnd,
+            Location:    req.Location,
+            Description: req.Description,
+            Image:       req.Image,
+            Flyer:       req.Flyer,
+            Category:    req.Category,
+        }).Error; err != nil {
+            return err
+        }
+
+        // Updates skips false and zero, so these settings are written on their own
+        settings := map[string]interface{}{}
+        if req.TransferDisabled != nil {
+            settings["transfer_disabled"] = *req.TransferDisabled
+        }
+        if req.ResaleEnabled != nil {
+            settings["resale_enabled"] = *req.ResaleEnabled
+        }
+        if req.ResaleMaxMarkup != nil {
+            settings["resale_max_markup"] = *req.ResaleMaxMarkup
+        }
+        if len(settings) > 0 {
+            if err := tx.Model(&event).Updates(settings).Error; err != nil {
+                return err
+            }
+        }
+
+        if resubmit {
+            return transitionEvent(tx, &event, "submitted", map[string]interface{}{
+                "submitted_at": time.Now(),
+            })
+        }
+        return nil
     })
-}
 
-func VerifyEvent(c *fiber.Ctx) error {
-    eventID := c.Params("id")
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        return c.Status(fiberErr.Code).JSON(fiber.Map{
+            "error": fiberErr.Message,
+        })
+    }
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to update event",
+        })
+    }
 
-    var event models.Event
-    if err := config.DB.Where("event_id = ?", eventID).First(&event).Error; err != nil {
-        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-            "error": "Event not found",
+    if reschedule != nil {
+        go notifyReschedule(event.Name, reschedule)
+    }
+
+    if revision != nil {
+        return c.JSON(fiber.Map{
+            "message":  "Event updated, changes to its key details wait for review",
+            "status":   event.Status,
+            "revision": revision,
         })
     }
 
-    if err := config.DB.Model(&event).Update("status", "approved").Error; err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Failed to verify event",
+    if resubmit {
+        return c.JSON(fiber.Map{
+            "message": "Event updated and sent back for review",
+            "status":  event.Status,
         })
     }
 
     return c.JSON(fiber.Map{
-        "message": "Event verified successfully",
+        "message": "Event updated successfully",
+        "status":  event.Status,
     })
 }
 
@@ -170,7 +255,25 @@ func DeleteEvent(c *fiber.Ctx) error {
         })
     }
 
-    if err := config.DB.Delete(&event).Error; err != nil {
+    // Sold tickets must keep their event, so those events are cancelled instead.
+    // Orders that were never paid are dropped with the event.
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        if eventHasSales(tx, event.EventID) {
+            return fiber.NewError(fiber.StatusConflict, "Event has sales and cannot be deleted, cancel it instead")
+        }
+        if err := closeUnpaidEventOrders(tx, event.EventID); err != nil {
+            return err
+        }
+        return tx.Delete(&event).Error
+    })
+
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        return c.Status(fiberErr.Code).JSON(fiber.Map{
+            "error": fiberErr.Message,
+        })
+    }
+    if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to delete event",
         })
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+    "log"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+type CancelEventRequest struct {
+    Reason string `json:"reason"`
+}
+
+// eventHasSales reports whether a paid order includes the event. Orders that
+// were refunded count too, since their tickets and payments still point at it.
+func eventHasSales(tx *gorm.DB, eventID string) bool {
+    var count int64
+    tx.Model(&models.Order{}).
+        Where("status IN ? AND order_id IN (?)", []string{"paid", "refunded"},
+            tx.Model(&models.OrderItem{}).Select("order_id").Where("event_id = ?", eventID)).
+        Count(&count)
+    return count > 0
+}
+
+// closeUnpaidEventOrders drops every order of the event still waiting for
+// payment. A payment that still arrives for one of them cannot take the
+// tickets again once the event is off sale, so processPaymentNotification
+// refunds it.
+func closeUnpaidEventOrders(tx *gorm.DB, eventID string) error {
+    var orderIDs []string
+    if err := tx.Model(&models.Order{}).
+        Where("status = ? AND order_id IN (?)", "pending_payment", tx.Model(&models.OrderItem{}).Select("order_id").Where("event_id = ?", eventID)).
+        Pluck("order_id", &orderIDs).Error; err != nil {
+        return err
+    }
+    for _, orderID := range orderIDs {
+        if err := closeUnpaidOrder(tx, orderID, "cancelled"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// cancelEventSales voids everything sold for a cancelled event: tickets,
+// resale listings, transfers, cart holds and unpaid orders. Paid orders get a
+// refund queued for their tickets of the event. Returns the holders to notify
+// and the refunds to send.
+func cancelEventSales(tx *gorm.DB, eventID string) ([]string, []string, error) {
+    // Tickets already given back for a refund keep their status
+    voidable := append([]string{"used"}, heldTicketStatuses...)
+
+    var holderIDs []string
+    if err := tx.Model(&models.Ticket{}).Distinct("owner_id").
+        Where("event_id = ? AND status IN ?", eventID, voidable).
+        Pluck("owner_id", &holderIDs).Error; err != nil {
+        return nil, nil, err
+    }
+
+    if err := tx.Model(&models.Ticket{}).
+        Where("event_id = ? AND status IN ?", eventID, voidable).
+        Update("status", "cancelled").Error; err != nil {
+        return nil, nil, err
+    }
+
+    var carts []models.Cart
+    if err := tx.Where("ticket_category_id IN (?)", tx.Model(&models.TicketCategory{}).Select("ticket_category_id").Where("event_id = ?", eventID)).
+        Find(&carts).Error; err != nil {
+        return nil, nil, err
+    }
+    for _, cart := range carts {
+        if err := removeCartItem(tx, &cart); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    // Orders still waiting for payment are dropped as a whole
+    if err := closeUnpaidEventOrders(tx, eventID); err != nil {
+        return nil, nil, err
+    }
+
+    // Resale buyers are refunded what they paid, so sellers lose their credit
+    // and are refunded their own purchase instead
+    if err := tx.Model(&models.SellerCredit{}).
+        Where("status = ? AND listing_id IN (?)", "available", tx.Model(&models.ResaleListing{}).Select("listing_id").Where("event_id = ?", eventID)).
+        Update("status", "reversed").Error; err != nil {
+        return nil, nil, err
+    }
+    if err := tx.Model(&models.ResaleListing{}).
+        Where("event_id = ? AND status IN ?", eventID, []string{"active", "held", "reserved"}).
+        Update("status", "cancelled").Error; err != nil {
+        return nil, nil, err
+    }
+
+    if err := tx.Model(&models.TicketTransfer{}).
+        Where("event_id = ? AND status = ?", eventID, "pending").
+        Updates(map[string]interface{}{
+            "status":       "cancelled",
+            "responded_at": time.Now(),
+        }).Error; err != nil {
+        return nil, nil, err
+    }
+
+    var paidOrders []models.Order
+    if err := tx.Where("status = ? AND order_id IN (?)", "paid", tx.Model(&models.OrderItem{}).Select("order_id").Where("event_id = ?", eventID)).
+        Find(&paidOrders).Error; err != nil {
+        return nil, nil, err
+    }
+
+    refundIDs := []string{}
+    for _, order := range paidOrders {
+        amount, err := eventRefundDue(tx, order.OrderID, eventID)
+        if err != nil {
+            return nil, nil, err
+        }
+        if amount < 0.005 {
+            continue
+        }
+
+        var payment models.Payment
+        if err := tx.Where("order_id = ? AND status = ?", order.OrderID, "paid").First(&payment).Error; err != nil {
+            return nil, nil, err
+        }
+
+        refund := models.Refund{EventID: &eventID, Amount: amount, Reason: "event_cancelled"}
+        if err := queueRefund(tx, &payment, &refund); err != nil {
+            return nil, nil, err
+        }
+        refundIDs = append(refundIDs, refund.RefundID)
+    }
+
+    return holderIDs, refundIDs, nil
+}
+
+func CancelEvent(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var req CancelEventRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    // Holders are told why their tickets stopped being valid
+    reason := strings.TrimSpace(req.Reason)
+    if reason == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "A reason is required when cancelling an event",
+        })
+    }
+
+    var holderIDs, refundIDs []string
+    err = config.DB.Transaction(func(tx *gorm.DB) error {
+        if err := transitionEvent(tx, event, "cancelled", map[string]interface{}{
+            "cancelled_at":        time.Now(),
+            "cancellation_reason": reason,
+        }); err != nil {
+            return err
+        }
+
+        var err error
+        holderIDs, refundIDs, err = cancelEventSales(tx, event.EventID)
+        return err
+    })
+    if err != nil {
+        return eventStatusError(c, err)
+    }
+
+    // Refunds that fail here are retried by the sweeper
+    go func() {
+        for _, refundID := range refundIDs {
+            if err := processRefund(refundID); err != nil {
+                log.Println("Failed to process refund", refundID+":", err)
+            }
+        }
+
+        body := "Unfortunately " + event.Name + " on " + event.DateStart.Format("2 Jan 2006") +
+            " has been cancelled by the organizer.\n\nReason: " + reason +
+            "\n\nYour tickets are no longer valid. Paid tickets are refunded to the original payment method."
+        for _, holderID := range holderIDs {
+            notifyUser(holderID, "Event cancelled: "+event.Name, body)
+        }
+    }()
+
+    return c.JSON(fiber.Map{
+        "message":        "Event cancelled",
+        "status":         event.Status,
+        "holders":        len(holderIDs),
+        "refunds_queued": len(refundIDs),
+    })
+}
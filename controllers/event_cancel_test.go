@@ -0,0 +1,58 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// TestPaymentAfterEventCancelledIsRefunded pays an order whose event was
+// cancelled while the buyer was still at the payment page.
+func TestPaymentAfterEventCancelledIsRefunded(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+    app := newPaymentTestApp()
+    app.Post("/api/events/:id/cancel", testAuth, CancelEvent)
+
+    category := seedOnSaleCategory(t, 5, 100)
+    buyer := seedUser(t, "user")
+    order, payment := checkoutTickets(t, app, buyer.UserID, category.TicketCategoryID, 2)
+
+    var event models.Event
+    config.DB.Where("event_id = ?", category.EventID).First(&event)
+    body, _ := json.Marshal(CancelEventRequest{Reason: "Venue closed"})
+    if status := doJSON(t, app, http.MethodPost, "/api/events/"+event.EventID+"/cancel", event.OwnerID, nil, body, nil); status != fiber.StatusOK {
+        t.Fatalf("cancel event: status %d", status)
+    }
+
+    var cancelled models.Order
+    config.DB.Where("order_id = ?", order.OrderID).First(&cancelled)
+    if cancelled.Status != "cancelled" {
+        t.Fatalf("order status = %q after the event was cancelled, want cancelled", cancelled.Status)
+    }
+
+    var paid struct {
+        Outcome string `json:"outcome"`
+    }
+    if status := doJSON(t, app, http.MethodPost, "/api/payments/mock/"+payment.Reference, buyer.UserID, nil, nil, &paid); status != fiber.StatusOK || paid.Outcome != "refunded" {
+        t.Fatalf("payment after cancellation: status %d, outcome %q, want refunded", status, paid.Outcome)
+    }
+
+    var refund models.Refund
+    if err := config.DB.Where("order_id = ? AND reason = ?", order.OrderID, "late_payment").First(&refund).Error; err != nil {
+        t.Fatal("no refund queued for the payment:", err)
+    }
+    if refund.Amount != payment.Amount || refund.Status != "completed" {
+        t.Errorf("refund of %.2f with status %q, want %.2f completed", refund.Amount, refund.Status, payment.Amount)
+    }
+
+    var tickets int64
+    config.DB.Model(&models.Ticket{}).Where("order_id = ?", order.OrderID).Count(&tickets)
+    if tickets != 0 {
+        t.Errorf("%d tickets minted for a cancelled event", tickets)
+    }
+}
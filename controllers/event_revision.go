@@ -0,0 +1,234 @@
+package controllers
+
+import (
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// stageEventRevision stores the key details of an update to a published event
+// with sales for review instead of applying them. A pending revision of the
+// event is updated, so the admin always reviews the latest version.
+func stageEventRevision(tx *gorm.DB, event *models.Event, req *CreateEventRequest) (*models.EventRevision, error) {
+    var revision models.EventRevision
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("event_id = ? AND status = ?", event.EventID, "pending").
+        First(&revision).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        revision = models.EventRevision{
+            EventID:     event.EventID,
+            Name:        event.Name,
+            DateStart:   event.DateStart,
+            DateEnd:     event.DateEnd,
+            Location:    event.Location,
+            Description: event.Description,
+            Category:    event.Category,
+            Status:      "pending",
+        }
+    } else if err != nil {
+        return nil, err
+    }
+
+    if req.Name != "" {
+        revision.Name = req.Name
+    }
+    if !req.DateStart.IsZero() {
+        revision.DateStart = req.DateStart
+    }
+    if !req.DateEnd.IsZero() {
+        revision.DateEnd = req.DateEnd
+    }
+    if req.Location != "" {
+        revision.Location = req.Location
+    }
+    if req.Description != "" {
+        revision.Description = req.Description
+    }
+    if req.Category != "" {
+        revision.Category = req.Category
+    }
+
+    if err := tx.Save(&revision).Error; err != nil {
+        return nil, err
+    }
+    return &revision, nil
+}
+
+// findPendingRevision loads a revision waiting for review and its event, both
+// locked until the review is stored.
+func findPendingRevision(tx *gorm.DB, revisionID string) (*models.EventRevision, *models.Event, error) {
+    var revision models.EventRevision
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("revision_id = ?", revisionID).First(&revision).Error; err != nil {
+        return nil, nil, fiber.NewError(fiber.StatusNotFound, "Revision not found")
+    }
+    if revision.Status != "pending" {
+        return nil, nil, fiber.NewError(fiber.StatusConflict, "Revision was already "+revision.Status)
+    }
+
+    var event models.Event
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("event_id = ?", revision.EventID).First(&event).Error; err != nil {
+        return nil, nil, fiber.NewError(fiber.StatusNotFound, "Event not found")
+    }
+    return &revision, &event, nil
+}
+
+// GetEventRevisions lists the changes the owner of an event sent for review.
+func GetEventRevisions(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var revisions []models.EventRevision
+    if err := config.DB.Where("event_id = ?", event.EventID).Order("created_at DESC").Find(&revisions).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch revisions",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "revisions": revisions,
+    })
+}
+
+// GetRevisionsForReview is the admin queue of changes to published events,
+// oldest first.
+func GetRevisionsForReview(c *fiber.Ctx) error {
+    status := c.Query("status", "pending")
+
+    var revisions []models.EventRevision
+    if err := config.DB.Where("status = ?", status).Order("updated_at").Find(&revisions).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch revisions",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "revisions": revisions,
+    })
+}
+
+// ApproveEventRevision applies a reviewed revision to its event. A new date
+// or venue is announced to holders like any other reschedule.
+func ApproveEventRevision(c *fiber.Ctx) error {
+    // The comment is optional when approving
+    var req ReviewEventRequest
+    c.BodyParser(&req)
+
+    var comment *string
+    if trimmed := strings.TrimSpace(req.Comment); trimmed != "" {
+        comment = &trimmed
+    }
+
+    var revision *models.EventRevision
+    var event *models.Event
+    var reschedule *models.EventReschedule
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var err error
+        revision, event, err = findPendingRevision(tx, c.Params("revisionId"))
+        if err != nil {
+            return err
+        }
+
+        if event.Status != "published" {
+            return fiber.NewError(fiber.StatusConflict, "Event is "+event.Status+" and can no longer be changed")
+        }
+
+        reschedule, err = recordReschedule(tx, event, &CreateEventRequest{
+            DateStart: revision.DateStart,
+            DateEnd:   revision.DateEnd,
+            Location:  revision.Location,
+        })
+        if err != nil {
+            return err
+        }
+
+        now := time.Now()
+        if err := tx.Model(event).Updates(map[string]interface{}{
+            "name":        revision.Name,
+            "date_start":  revision.DateStart,
+            "date_end":    revision.DateEnd,
+            "location":    revision.Location,
+            "description": revision.Description,
+            "category":    revision.Category,
+            "reviewed_at": now,
+        }).Error; err != nil {
+            return err
+        }
+
+        return tx.Model(revision).Updates(map[string]interface{}{
+            "status":         "approved",
+            "review_comment": comment,
+            "reviewed_at":    now,
+        }).Error
+    })
+    if err != nil {
+        return eventStatusError(c, err)
+    }
+
+    if reschedule != nil {
+        go notifyReschedule(revision.Name, reschedule)
+    }
+
+    body := "The changes to your event " + revision.Name + " were approved and are now live."
+    if comment != nil {
+        body += "\n\nReviewer comment: " + *comment
+    }
+    notifyUser(event.OwnerID, "Event changes approved: "+revision.Name, body)
+
+    return c.JSON(fiber.Map{
+        "message":  "Revision approved",
+        "revision": revision,
+    })
+}
+
+// RejectEventRevision drops a revision. The event keeps its current details.
+func RejectEventRevision(c *fiber.Ctx) error {
+    var req ReviewEventRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    comment := strings.TrimSpace(req.Comment)
+    if comment == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "A comment is required when rejecting a revision",
+        })
+    }
+
+    var revision *models.EventRevision
+    var event *models.Event
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var err error
+        revision, event, err = findPendingRevision(tx, c.Params("revisionId"))
+        if err != nil {
+            return err
+        }
+
+        return tx.Model(revision).Updates(map[string]interface{}{
+            "status":         "rejected",
+            "review_comment": comment,
+            "reviewed_at":    time.Now(),
+        }).Error
+    })
+    if err != nil {
+        return eventStatusError(c, err)
+    }
+
+    notifyUser(event.OwnerID, "Event changes need work: "+event.Name,
+        "The changes to your event "+event.Name+" were not approved and the event keeps its current details."+
+            "\n\nReviewer comment: "+comment)
+
+    return c.JSON(fiber.Map{
+        "message":  "Revision rejected",
+        "revision": revision,
+    })
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// TestPublishedEventChangesWaitForReview moves the venue of an event that
+// sold tickets. The event stays on sale at the old venue until an admin
+// approves the change, which is then announced as a reschedule.
+func TestPublishedEventChangesWaitForReview(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+    app := newPaymentTestApp()
+    app.Put("/api/events/:id", testAuth, UpdateEvent)
+    app.Patch("/api/events/revisions/:revisionId/approve", testAuth, ApproveEventRevision)
+
+    category := seedOnSaleCategory(t, 5, 100)
+    buyer := seedUser(t, "user")
+    admin := seedUser(t, "admin")
+    _, payment := checkoutTickets(t, app, buyer.UserID, category.TicketCategoryID, 1)
+    if status := doJSON(t, app, http.MethodPost, "/api/payments/mock/"+payment.Reference, buyer.UserID, nil, nil, nil); status != fiber.StatusOK {
+        t.Fatalf("mock payment: status %d", status)
+    }
+
+    var event models.Event
+    config.DB.Where("event_id = ?", category.EventID).First(&event)
+
+    body, _ := json.Marshal(CreateEventRequest{Location: "Bandung"})
+    var updated struct {
+        Status   string               `json:"status"`
+        Revision models.EventRevision `json:"revision"`
+    }
+    if status := doJSON(t, app, http.MethodPut, "/api/events/"+event.EventID, event.OwnerID, nil, body, &updated); status != fiber.StatusOK {
+        t.Fatalf("update event: status %d", status)
+    }
+    if updated.Status != "published" || updated.Revision.Status != "pending" || updated.Revision.Location != "Bandung" {
+        t.Fatalf("update gave event %q with revision %+v, want published with a pending move to Bandung", updated.Status, updated.Revision)
+    }
+
+    var pending models.Event
+    config.DB.Where("event_id = ?", event.EventID).First(&pending)
+    if pending.Location != event.Location || pending.Status != "published" {
+        t.Errorf("event at %q with status %q before review, want %q published", pending.Location, pending.Status, event.Location)
+    }
+
+    var reschedules int64
+    config.DB.Model(&models.EventReschedule{}).Where("event_id = ?", event.EventID).Count(&reschedules)
+    if reschedules != 0 {
+        t.Errorf("%d reschedules announced before review", reschedules)
+    }
+
+    if status := doJSON(t, app, http.MethodPatch, "/api/events/revisions/"+updated.Revision.RevisionID+"/approve", admin.UserID, nil, nil, nil); status != fiber.StatusOK {
+        t.Fatalf("approve revision: status %d", status)
+    }
+
+    var approved models.Event
+    config.DB.Where("event_id = ?", event.EventID).First(&approved)
+    if approved.Location != "Bandung" || approved.Status != "published" {
+        t.Errorf("event at %q with status %q after review, want Bandung published", approved.Location, approved.Status)
+    }
+
+    var reschedule models.EventReschedule
+    if err := config.DB.Where("event_id = ?", event.EventID).First(&reschedule).Error; err != nil {
+        t.Fatal("approved move was not recorded as a reschedule:", err)
+    }
+    if reschedule.PreviousLocation != event.Location || reschedule.Location != "Bandung" {
+        t.Errorf("reschedule from %q to %q, want %q to Bandung", reschedule.PreviousLocation, reschedule.Location, event.Location)
+    }
+
+    if status := doJSON(t, app, http.MethodPatch, "/api/events/revisions/"+updated.Revision.RevisionID+"/approve", admin.UserID, nil, nil, nil); status != fiber.StatusConflict {
+        t.Errorf("approving twice: status %d, want %d", status, fiber.StatusConflict)
+    }
+}
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+const (
+    defaultEventPageSize = 20
+    maxEventPageSize     = 100
+)
+
+// eventMinPriceSQL is the cheapest ticket category of an event still on sale.
+const eventMinPriceSQL = "(SELECT MIN(tc.price) FROM ticket_categories tc WHERE tc.event_id = events.event_id AND tc.status <> 'retired')"
+
+type eventSort struct {
+    Expr string
+    Desc bool
+    Kind string // time, number or string, to decode cursor values
+}
+
+var eventSorts = map[string]eventSort{
+    "date":   {Expr: "events.date_start", Kind: "time"},
+    "-date":  {Expr: "events.date_start", Desc: true, Kind: "time"},
+    "price":  {Expr: "COALESCE(" + eventMinPriceSQL + ", 0)", Kind: "number"},
+    "-price": {Expr: "COALESCE(" + eventMinPriceSQL + ", 0)", Desc: true, Kind: "number"},
+    "name":   {Expr: "events.name", Kind: "string"},
+    "newest": {Expr: "events.created_at", Desc: true, Kind: "time"},
+}
+
+// EventListing is an event in search results with its lowest ticket price.
+type EventListing struct {
+    models.Event
+    MinPrice  float64 `json:"min_price"`
+    SortValue string  `json:"-"`
+}
+
+// eventCursor points just after the last event of a page. Sort is kept so a
+// cursor cannot be replayed against a different ordering.
+type eventCursor struct {
+    Sort    string `json:"s"`
+    Value   string `json:"v"`
+    EventID string `json:"id"`
+}
+
+func encodeEventCursor(cursor eventCursor) string {
+    data, _ := json.Marshal(cursor)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeEventCursor(value string) (*eventCursor, error) {
+    data, err := base64.RawURLEncoding.DecodeString(value)
+    if err != nil {
+        return nil, err
+    }
+    var cursor eventCursor
+    if err := json.Unmarshal(data, &cursor); err != nil {
+        return nil, err
+    }
+    return &cursor, nil
+}
+
+// parseSearchDate accepts RFC3339 timestamps and plain dates. A plain date
+// used as an upper bound covers the whole day.
+func parseSearchDate(value string, endOfDay bool) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, value); err == nil {
+        return t, nil
+    }
+    day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+    if err != nil {
+        return time.Time{}, err
+    }
+    if endOfDay {
+        return day.Add(24*time.Hour - time.Millisecond), nil
+    }
+    return day, nil
+}
+
+// InnoDB leaves words shorter than innodb_ft_min_token_size (3 by default)
+// and its default stopwords out of the full-text index.
+const minSearchTokenLength = 3
+
+var searchStopwords = map[string]bool{
+    "a": true, "about": true, "an": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+    "com": true, "de": true, "en": true, "for": true, "from": true, "how": true, "i": true, "in": true,
+    "is": true, "it": true, "la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+    "this": true, "to": true, "was": true, "what": true, "when": true, "where": true, "who": true,
+    "will": true, "with": true, "und": true, "www": true,
+}
+
+// searchTerms turns a keyword query into a boolean full-text search where every
+// word must match, as a prefix. Words the index never contains are dropped,
+// since requiring them would match nothing.
+func searchTerms(q string) string {
+    words := strings.FieldsFunc(q, func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+    terms := make([]string, 0, len(words))
+    for _, word := range words {
+        if utf8.RuneCountInString(word) < minSearchTokenLength || searchStopwords[strings.ToLower(word)] {
+            continue
+        }
+        terms = append(terms, "+"+word+"*")
+    }
+    return strings.Join(terms, " ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// eventSearchQuery applies the listing filters from the query string to
+// published events.
+func eventSearchQuery(c *fiber.Ctx) (*gorm.DB, error) {
+    query := config.DB.Model(&models.Event{}).Where("events.status = ?", "published")
+
+    if terms := searchTerms(c.Query("q")); terms != "" {
+        query = query.Where("MATCH(events.name, events.description) AGAINST (? IN BOOLEAN MODE)", terms)
+    }
+
+    if category := strings.TrimSpace(c.Query("category")); category != "" {
+        query = query.Where("events.category = ?", category)
+    }
+
+    if location := strings.TrimSpace(c.Query("location")); location != "" {
+        query = query.Where("events.location LIKE ?", "%"+likeEscaper.Replace(location)+"%")
+    }
+
+    // Events overlapping the range are included
+    if from := c.Query("date_from"); from != "" {
+        fromTime, err := parseSearchDate(from, false)
+        if err != nil {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "date_from must be a date or an RFC3339 timestamp")
+        }
+        query = query.Where("events.date_end >= ?", fromTime)
+    }
+    if to := c.Query("date_to"); to != "" {
+        toTime, err := parseSearchDate(to, true)
+        if err != nil {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "date_to must be a date or an RFC3339 timestamp")
+        }
+        query = query.Where("events.date_start <= ?", toTime)
+    }
+
+    // One category on sale has to fall inside the whole price range
+    priceSQL := []string{}
+    priceArgs := []interface{}{}
+    for _, bound := range []struct{ param, op string }{{"price_min", ">="}, {"price_max", "<="}} {
+        value := c.Query(bound.param)
+        if value == "" {
+            continue
+        }
+        price, err := strconv.ParseFloat(value, 64)
+        if err != nil || price < 0 {
+            return nil, fiber.NewError(fiber.StatusBadRequest, bound.param+" must be a non-negative number")
+        }
+        priceSQL = append(priceSQL, "tc.price "+bound.op+" ?")
+        priceArgs = append(priceArgs, price)
+    }
+    if len(priceSQL) > 0 {
+        query = query.Where("EXISTS (SELECT 1 FROM ticket_categories tc WHERE tc.event_id = events.event_id AND tc.status <> 'retired' AND "+
+            strings.Join(priceSQL, " AND ")+")", priceArgs...)
+    }
+
+    if c.Query("available") == "true" {
+        query = query.Where("EXISTS (SELECT 1 FROM ticket_categories tc WHERE tc.event_id = events.event_id AND tc.status <> 'retired' AND tc.sold + tc.held < tc.quota)")
+    }
+
+    return query, nil
+}
+
+func searchEventsError(c *fiber.Ctx, err error) error {
+    fiberErr, ok := err.(*fiber.Error)
+    if !ok {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch events",
+        })
+    }
+    return c.Status(fiberErr.Code).JSON(fiber.Map{
+        "error": fiberErr.Message,
+    })
+}
+
+// GetEvents lists published events with filters, a sort order and cursor
+// pagination. total counts every match, not just the page.
+func GetEvents(c *fiber.Ctx) error {
+    sortKey := c.Query("sort", "date")
+    sort, ok := eventSorts[sortKey]
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "sort must be one of date, -date, price, -price, name or newest",
+        })
+    }
+
+    limit := c.QueryInt("limit", defaultEventPageSize)
+    if limit <= 0 || limit > maxEventPageSize {
+        limit = defaultEventPageSize
+    }
+
+    countQuery, err := eventSearchQuery(c)
+    if err != nil {
+        return searchEventsError(c, err)
+    }
+    var total int64
+    if err := countQuery.Count(&total).Error; err != nil {
+        return searchEventsError(c, err)
+    }
+
+    query, err := eventSearchQuery(c)
+    if err != nil {
+        return searchEventsError(c, err)
+    }
+
+    if value := c.Query("cursor"); value != "" {
+        cursor, err := decodeEventCursor(value)
+        if err != nil || cursor.Sort != sortKey {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Invalid cursor",
+            })
+        }
+
+        var after interface{} = cursor.Value
+        switch sort.Kind {
+        case "time":
+            after, err = time.Parse(time.RFC3339Nano, cursor.Value)
+        case "number":
+            after, err = strconv.ParseFloat(cursor.Value, 64)
+        }
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Invalid cursor",
+            })
+        }
+
+        op := ">"
+        if sort.Desc {
+            op = "<"
+        }
+        query = query.Where("("+sort.Expr+" "+op+" ? OR ("+sort.Expr+" = ? AND events.event_id "+op+" ?))", after, after, cursor.EventID)
+    }
+
+    direction := " ASC"
+    if sort.Desc {
+        direction = " DESC"
+    }
+
+    // One extra row tells whether there is a next page
+    var events []EventListing
+    if err := query.
+        Select("events.*, COALESCE(" + eventMinPriceSQL + ", 0) AS min_price, " + sort.Expr + " AS sort_value").
+        Order(sort.Expr + direction).
+        Order("events.event_id" + direction).
+        Limit(limit + 1).
+        Find(&events).Error; err != nil {
+        return searchEventsError(c, err)
+    }
+
+    var nextCursor *string
+    if len(events) > limit {
+        events = events[:limit]
+        last := events[limit-1]
+        cursor := encodeEventCursor(eventCursor{Sort: sortKey, Value: last.SortValue, EventID: last.EventID})
+        nextCursor = &cursor
+    }
+    if events == nil {
+        events = []EventListing{}
+    }
+
+    return c.JSON(fiber.Map{
+        "events":      events,
+        "total":       total,
+        "next_cursor": nextCursor,
+    })
+}
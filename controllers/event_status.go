@@ -0,0 +1,328 @@
+package controllers
+
+import (
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// Events move through these states:
+//
+//     draft -> submitted -> approved -> published -> completed
+//                  |  ^                     |
+//                  v  |                     +-> cancelled
+//                rejected
+//
+// Changing the key details of an approved or published event sends it back
+// to submitted. Published events that already sold tickets stay published
+// and the changes wait in an EventRevision for an admin instead. Only
+// published events are listed and sell tickets.
+var eventTransitions = map[string][]string{
+    "draft":     {"submitted"},
+    "submitted": {"approved", "rejected", "cancelled"},
+    "rejected":  {"submitted", "cancelled"},
+    "approved":  {"published", "submitted", "cancelled"},
+    "published": {"submitted", "completed", "cancelled"},
+}
+
+// publicEventStatuses are the states in which anyone can look an event up.
+var publicEventStatuses = []string{"published", "cancelled", "completed"}
+
+// transitionEvent moves an event to a new state. The update only applies while
+// the event is still in the state it was loaded in, so two reviewers acting at
+// once cannot both succeed.
+func transitionEvent(tx *gorm.DB, event *models.Event, to string, fields map[string]interface{}) error {
+    if !containsString(eventTransitions[event.Status], to) {
+        return fiber.NewError(fiber.StatusConflict, "Event is "+event.Status+" and cannot be "+to)
+    }
+
+    if fields == nil {
+        fields = map[string]interface{}{}
+    }
+    fields["status"] = to
+
+    result := tx.Model(&models.Event{}).Where("event_id = ? AND status = ?", event.EventID, event.Status).Updates(fields)
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return fiber.NewError(fiber.StatusConflict, "Event was changed in the meantime, please reload it")
+    }
+
+    event.Status = to
+    return nil
+}
+
+// eventOnSale reports whether tickets of the event can be bought.
+func eventOnSale(tx *gorm.DB, eventID string) bool {
+    var count int64
+    tx.Model(&models.Event{}).Where("event_id = ? AND status = ?", eventID, "published").Count(&count)
+    return count > 0
+}
+
+// eventKeyDetailsChanged reports whether an update touches the details an
+// admin reviewed. Empty fields in the request are left unchanged.
+func eventKeyDetailsChanged(event *models.Event, req *CreateEventRequest) bool {
+    return (req.Name != "" && req.Name != event.Name) ||
+        (!req.DateStart.IsZero() && !req.DateStart.Equal(event.DateStart)) ||
+        (!req.DateEnd.IsZero() && !req.DateEnd.Equal(event.DateEnd)) ||
+        (req.Location != "" && req.Location != event.Location) ||
+        (req.Description != "" && req.Description != event.Description) ||
+        (req.Category != "" && req.Category != event.Category)
+}
+
+// MigrateLegacyEventStatuses maps events created before the review workflow:
+// pending events wait for review and approved events were already on sale.
+func MigrateLegacyEventStatuses() (int64, error) {
+    submitted := config.DB.Model(&models.Event{}).Where("status = ?", "pending").UpdateColumns(map[string]interface{}{
+        "status":       "submitted",
+        "submitted_at": gorm.Expr("updated_at"),
+    })
+    if submitted.Error != nil {
+        return 0, submitted.Error
+    }
+
+    published := config.DB.Model(&models.Event{}).Where("status = ?", "approved").UpdateColumns(map[string]interface{}{
+        "status":       "published",
+        "reviewed_at":  gorm.Expr("updated_at"),
+        "published_at": gorm.Expr("updated_at"),
+    })
+    if published.Error != nil {
+        return submitted.RowsAffected, published.Error
+    }
+
+    return submitted.RowsAffected + published.RowsAffected, nil
+}
+
+// CompleteEndedEvents marks published events whose end date has passed as
+// completed. Tickets that were scanned in but allowed more entries are used
+// up with their event.
+func CompleteEndedEvents() (int64, error) {
+    var eventIDs []string
+    if err := config.DB.Model(&models.Event{}).
+        Where("status = ? AND date_end < ?", "published", time.Now()).
+        Pluck("event_id", &eventIDs).Error; err != nil {
+        return 0, err
+    }
+    if len(eventIDs) == 0 {
+        return 0, nil
+    }
+
+    var completed int64
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        result := tx.Model(&models.Event{}).
+            Where("event_id IN ? AND status = ?", eventIDs, "published").
+            Update("status", "completed")
+        if result.Error != nil {
+            return result.Error
+        }
+        completed = result.RowsAffected
+
+        return tx.Model(&models.Ticket{}).
+            Where("event_id IN ? AND status = ? AND checked_in_at IS NOT NULL", eventIDs, "active").
+            Update("status", "used").Error
+    })
+    return completed, err
+}
+
+func eventStatusError(c *fiber.Ctx, err error) error {
+    status := fiber.StatusInternalServerError
+    message := "Failed to update event status"
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        status = fiberErr.Code
+        message = fiberErr.Message
+    }
+    return c.Status(status).JSON(fiber.Map{
+        "error": message,
+    })
+}
+
+func SubmitEvent(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    if strings.TrimSpace(event.Name) == "" || strings.TrimSpace(event.Location) == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Event needs a name and a location before it can be submitted",
+        })
+    }
+
+    if !event.DateStart.After(time.Now()) || !event.DateEnd.After(event.DateStart) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Event must start in the future and end after it starts",
+        })
+    }
+
+    var categories int64
+    config.DB.Model(&models.TicketCategory{}).Where("event_id = ? AND status <> ?", event.EventID, "retired").Count(&categories)
+    if categories == 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Add at least one ticket category before submitting the event",
+        })
+    }
+
+    if err := transitionEvent(config.DB, event, "submitted", map[string]interface{}{
+        "submitted_at": time.Now(),
+    }); err != nil {
+        return eventStatusError(c, err)
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Event submitted for review",
+        "status":  event.Status,
+    })
+}
+
+type ReviewEventRequest struct {
+    Comment string `json:"comment"`
+}
+
+func VerifyEvent(c *fiber.Ctx) error {
+    eventID := c.Params("id")
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", eventID).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    // The comment is optional when approving
+    var req ReviewEventRequest
+    c.BodyParser(&req)
+
+    var comment *string
+    if trimmed := strings.TrimSpace(req.Comment); trimmed != "" {
+        comment = &trimmed
+    }
+
+    if err := transitionEvent(config.DB, &event, "approved", map[string]interface{}{
+        "reviewed_at":      time.Now(),
+        "approval_comment": comment,
+    }); err != nil {
+        return eventStatusError(c, err)
+    }
+
+    body := "Your event " + event.Name + " was approved. Publish it to start selling tickets."
+    if comment != nil {
+        body += "\n\nReviewer comment: " + *comment
+    }
+    notifyUser(event.OwnerID, "Event approved: "+event.Name, body)
+
+    return c.JSON(fiber.Map{
+        "message": "Event verified successfully",
+        "status":  event.Status,
+    })
+}
+
+func RejectEvent(c *fiber.Ctx) error {
+    eventID := c.Params("id")
+
+    var req ReviewEventRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    comment := strings.TrimSpace(req.Comment)
+    if comment == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "A comment is required when rejecting an event",
+        })
+    }
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", eventID).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if err := transitionEvent(config.DB, &event, "rejected", map[string]interface{}{
+        "reviewed_at":      time.Now(),
+        "approval_comment": comment,
+    }); err != nil {
+        return eventStatusError(c, err)
+    }
+
+    notifyUser(event.OwnerID, "Event needs changes: "+event.Name,
+        "Your event "+event.Name+" was not approved.\n\nReviewer comment: "+comment+
+            "\n\nUpdate the event and submit it again when it is ready.")
+
+    return c.JSON(fiber.Map{
+        "message": "Event rejected",
+        "status":  event.Status,
+    })
+}
+
+func PublishEvent(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    if !event.DateEnd.After(time.Now()) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Event has already ended",
+        })
+    }
+
+    fields := map[string]interface{}{}
+    if event.PublishedAt == nil {
+        fields["published_at"] = time.Now()
+    }
+    if err := transitionEvent(config.DB, event, "published", fields); err != nil {
+        return eventStatusError(c, err)
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Event published",
+        "status":  event.Status,
+    })
+}
+
+// GetMyEvents lists the EO's own events in every state, with review comments.
+func GetMyEvents(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    query := config.DB.Where("owner_id = ?", userID)
+    if status := c.Query("status"); status != "" {
+        query = query.Where("status = ?", status)
+    }
+
+    var events []models.Event
+    if err := query.Order("created_at DESC").Find(&events).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch events",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "events": events,
+    })
+}
+
+// GetEventsForReview is the admin review queue, oldest submission first.
+func GetEventsForReview(c *fiber.Ctx) error {
+    status := c.Query("status", "submitted")
+
+    var events []models.Event
+    if err := config.DB.Where("status = ?", status).Order("submitted_at, created_at").Find(&events).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch events",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "events": events,
+    })
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+    "testing"
+    "time"
+
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// TestCompleteEndedEventsUsesUpScannedTickets ends an event with a reentry
+// ticket that was scanned in and one that never was. Only the scanned one
+// is used up, so it can no longer be resold or refunded.
+func TestCompleteEndedEventsUsesUpScannedTickets(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+
+    category := seedOnSaleCategory(t, 5, 100)
+    config.DB.Model(&category).Update("admission_policy", "reentry")
+    holder := seedUser(t, "user")
+
+    scanned, err := newTicket(category.EventID, category.TicketCategoryID, holder.UserID, "order")
+    if err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now()
+    scanned.CheckedInAt = &now
+    unscanned, err := newTicket(category.EventID, category.TicketCategoryID, holder.UserID, "order")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, ticket := range []*models.Ticket{&scanned, &unscanned} {
+        if err := config.DB.Create(ticket).Error; err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    config.DB.Model(&models.Event{}).Where("event_id = ?", category.EventID).Updates(map[string]interface{}{
+        "date_start": now.Add(-5 * time.Hour),
+        "date_end":   now.Add(-time.Hour),
+    })
+    if _, err := CompleteEndedEvents(); err != nil {
+        t.Fatal(err)
+    }
+
+    var event models.Event
+    config.DB.Where("event_id = ?", category.EventID).First(&event)
+    if event.Status != "completed" {
+        t.Errorf("event status = %q, want completed", event.Status)
+    }
+
+    for ticket, want := range map[string]string{scanned.TicketID: "used", unscanned.TicketID: "active"} {
+        var after models.Ticket
+        config.DB.Where("ticket_id = ?", ticket).First(&after)
+        if after.Status != want {
+            t.Errorf("ticket %s status = %q, want %q", ticket, after.Status, want)
+        }
+    }
+}
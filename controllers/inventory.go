@@ -0,0 +1,123 @@
+package controllers
+
+import (
+    "errors"
+
+    "gorm.io/gorm"
+    "ticketing-backend/models"
+)
+
+var (
+    ErrNotEnoughTickets = errors.New("not enough tickets available")
+    ErrInvalidQuantity  = errors.New("quantity must be greater than 0")
+)
+
+// reserveTickets adds quantity to the sold count of a category in a single
+// conditional UPDATE, so the quota check and the increment cannot be split by
+// a concurrent buyer. Tickets held in carts count against the quota too.
+func reserveTickets(tx *gorm.DB, ticketCategoryID string, quantity int) error {
+    if quantity <= 0 {
+        return ErrInvalidQuantity
+    }
+
+    result := tx.Model(&models.TicketCategory{}).
+        Where("ticket_category_id = ? AND status <> ? AND sold + held + ? <= quota", ticketCategoryID, "retired", quantity).
+        Update("sold", gorm.Expr("sold + ?", quantity))
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// holdTickets reserves quantity for a cart without selling it yet.
+func holdTickets(tx *gorm.DB, ticketCategoryID string, quantity int) error {
+    if quantity <= 0 {
+        return ErrInvalidQuantity
+    }
+
+    result := tx.Model(&models.TicketCategory{}).
+        Where("ticket_category_id = ? AND status <> ? AND sold + held + ? <= quota", ticketCategoryID, "retired", quantity).
+        Update("held", gorm.Expr("held + ?", quantity))
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// releaseHeldTickets gives a cart hold back to the category.
+func releaseHeldTickets(tx *gorm.DB, ticketCategoryID string, quantity int) error {
+    return tx.Model(&models.TicketCategory{}).
+        Where("ticket_category_id = ?", ticketCategoryID).
+        Update("held", gorm.Expr("GREATEST(held - ?, 0)", quantity)).Error
+}
+
+// sellHeldTickets turns a cart hold into sold tickets. The quota is checked
+// again in case it was lowered while the cart was held.
+func sellHeldTickets(tx *gorm.DB, ticketCategoryID string, quantity int) error {
+    result := tx.Model(&models.TicketCategory{}).
+        Where("ticket_category_id = ? AND held >= ? AND sold + ? <= quota", ticketCategoryID, quantity, quantity).
+        Updates(map[string]interface{}{
+            "held": gorm.Expr("held - ?", quantity),
+            "sold": gorm.Expr("sold + ?", quantity),
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// unsellHeldTickets turns tickets sold from a cart hold back into a hold, for
+// an order that could not be charged.
+func unsellHeldTickets(tx *gorm.DB, ticketCategoryID string, quantity int) error {
+    result := tx.Model(&models.TicketCategory{}).
+        Where("ticket_category_id = ? AND sold >= ?", ticketCategoryID, quantity).
+        Updates(map[string]interface{}{
+            "sold": gorm.Expr("sold - ?", quantity),
+            "held": gorm.Expr("held + ?", quantity),
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// releaseTickets gives sold tickets back to the category, e.g. when an order
+// is never paid.
+func releaseTickets(tx *gorm.DB, ticketCategoryID string, quantity int) error {
+    return tx.Model(&models.TicketCategory{}).
+        Where("ticket_category_id = ?", ticketCategoryID).
+        Update("sold", gorm.Expr("GREATEST(sold - ?, 0)", quantity)).Error
+}
+
+// reserveOrderItem takes the inventory behind an order line again: sold count
+// for new tickets, the listing for a resale ticket.
+func reserveOrderItem(tx *gorm.DB, item models.OrderItem) error {
+    // Nothing can be taken again once the event stopped selling
+    if !eventOnSale(tx, item.EventID) {
+        return ErrNotEnoughTickets
+    }
+    if item.ResaleListingID != nil {
+        return reserveListing(tx, *item.ResaleListingID, item.OrderID)
+    }
+    return reserveTickets(tx, item.TicketCategoryID, item.Quantity)
+}
+
+// releaseOrderItem gives the inventory behind an unpaid order line back.
+func releaseOrderItem(tx *gorm.DB, item models.OrderItem) error {
+    if item.ResaleListingID != nil {
+        return releaseListing(tx, *item.ResaleListingID, item.OrderID)
+    }
+    return releaseTickets(tx, item.TicketCategoryID, item.Quantity)
+}
\ No newline at end of file
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sync"
+    "testing"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+func TestInventoryRejectsEmptyQuantity(t *testing.T) {
+    // The quantity is checked before the database is touched
+    if err := reserveTickets(nil, "category", 0); !errors.Is(err, ErrInvalidQuantity) {
+        t.Errorf("reserveTickets(0) = %v, want ErrInvalidQuantity", err)
+    }
+    if err := holdTickets(nil, "category", -1); !errors.Is(err, ErrInvalidQuantity) {
+        t.Errorf("holdTickets(-1) = %v, want ErrInvalidQuantity", err)
+    }
+}
+
+// newInventoryTestApp routes both ways of buying: directly and through the
+// cart.
+func newInventoryTestApp() *fiber.App {
+    app := newPaymentTestApp()
+    app.Post("/api/tickets", testAuth, CreateTicket)
+    return app
+}
+
+// buyThroughCart holds a ticket in the buyer's cart and checks out. Returns
+// the status of the step that failed, or of the checkout.
+func buyThroughCart(t *testing.T, app *fiber.App, buyerID string, body []byte) int {
+    if status := doJSON(t, app, http.MethodPost, "/api/cart", buyerID, nil, body, nil); status != fiber.StatusOK {
+        return status
+    }
+    return doJSON(t, app, http.MethodPost, "/api/checkout", buyerID, nil, nil, nil)
+}
+
+// TestConcurrentCheckoutsDoNotOversell races buyers for one category, half of
+// them buying directly and half checking out a cart, and checks that exactly
+// the quota is handed out.
+func TestConcurrentCheckoutsDoNotOversell(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+    app := newInventoryTestApp()
+
+    const quota = 50
+    const buyers = 400
+    category := seedOnSaleCategory(t, quota, 100)
+    body, _ := json.Marshal(CreateTicketRequest{TicketCategoryID: category.TicketCategoryID, Quantity: 1})
+
+    buyerIDs := make([]string, buyers)
+    for i := range buyerIDs {
+        buyerIDs[i] = seedUser(t, "user").UserID
+    }
+
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    succeeded, soldOut := 0, 0
+    var failures []int
+
+    start := make(chan struct{})
+    for i := 0; i < buyers; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            <-start
+
+            var status int
+            if i%2 == 0 {
+                status = doJSON(t, app, http.MethodPost, "/api/tickets", buyerIDs[i], nil, body, nil)
+            } else {
+                status = buyThroughCart(t, app, buyerIDs[i], body)
+            }
+
+            mu.Lock()
+            defer mu.Unlock()
+            switch status {
+            case fiber.StatusOK, fiber.StatusCreated:
+                succeeded++
+            case fiber.StatusBadRequest:
+                soldOut++
+            default:
+                failures = append(failures, status)
+            }
+        }(i)
+    }
+    close(start)
+    wg.Wait()
+
+    for _, status := range failures {
+        t.Error("unexpected status:", status)
+    }
+    if succeeded != quota {
+        t.Errorf("%d buyers got a ticket, want %d", succeeded, quota)
+    }
+    if succeeded+soldOut+len(failures) != buyers {
+        t.Errorf("%d outcomes for %d buyers", succeeded+soldOut+len(failures), buyers)
+    }
+
+    var after models.TicketCategory
+    if err := config.DB.Where("ticket_category_id = ?", category.TicketCategoryID).First(&after).Error; err != nil {
+        t.Fatal(err)
+    }
+    if after.Sold != quota || after.Held != 0 {
+        t.Errorf("sold %d, held %d, want %d sold and none held", after.Sold, after.Held, quota)
+    }
+}
+
+// TestConcurrentHoldsAndSalesRespectQuota checks out full carts while other
+// buyers keep trying to buy directly, which must never push sold + held past
+// the quota.
+func TestConcurrentHoldsAndSalesRespectQuota(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+    app := newInventoryTestApp()
+
+    const quota = 20
+    category := seedOnSaleCategory(t, quota, 100)
+    body, _ := json.Marshal(CreateTicketRequest{TicketCategoryID: category.TicketCategoryID, Quantity: 1})
+
+    // Fill the category with cart holds, then check them out while new
+    // buyers race in
+    holderIDs := make([]string, quota)
+    buyerIDs := make([]string, quota)
+    for i := 0; i < quota; i++ {
+        holderIDs[i] = seedUser(t, "user").UserID
+        buyerIDs[i] = seedUser(t, "user").UserID
+        if status := doJSON(t, app, http.MethodPost, "/api/cart", holderIDs[i], nil, body, nil); status != fiber.StatusOK {
+            t.Fatalf("add to cart: status %d", status)
+        }
+    }
+
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    var extra int
+    var failures []int
+
+    start := make(chan struct{})
+    for i := 0; i < quota; i++ {
+        wg.Add(2)
+        go func(i int) {
+            defer wg.Done()
+            <-start
+            if status := doJSON(t, app, http.MethodPost, "/api/checkout", holderIDs[i], nil, nil, nil); status != fiber.StatusOK {
+                mu.Lock()
+                failures = append(failures, status)
+                mu.Unlock()
+            }
+        }(i)
+        go func(i int) {
+            defer wg.Done()
+            <-start
+            status := doJSON(t, app, http.MethodPost, "/api/tickets", buyerIDs[i], nil, body, nil)
+            mu.Lock()
+            defer mu.Unlock()
+            if status == fiber.StatusCreated {
+                extra++
+            } else if status != fiber.StatusBadRequest {
+                failures = append(failures, status)
+            }
+        }(i)
+    }
+    close(start)
+    wg.Wait()
+
+    for _, status := range failures {
+        t.Error("unexpected status:", status)
+    }
+    if extra != 0 {
+        t.Errorf("%d tickets sold on top of the held ones", extra)
+    }
+
+    var after models.TicketCategory
+    if err := config.DB.Where("ticket_category_id = ?", category.TicketCategoryID).First(&after).Error; err != nil {
+        t.Fatal(err)
+    }
+    if after.Sold != quota || after.Held != 0 {
+        t.Errorf("sold %d, held %d, want %d sold and none held", after.Sold, after.Held, quota)
+    }
+}
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+    "encoding/base64"
+    "sort"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+const maxSyncBatch = 5000
+
+type OfflineScan struct {
+    Code         string    `json:"code"`
+    Gate         string    `json:"gate"`
+    Direction    string    `json:"direction"`
+    ScannedAt    time.Time `json:"scanned_at"`
+    DeviceScanID string    `json:"device_scan_id"`
+    Outcome      string    `json:"outcome"`
+}
+
+type SyncScansRequest struct {
+    Device string        `json:"device"`
+    Scans  []OfflineScan `json:"scans"`
+}
+
+type manifestTicket struct {
+    TicketID         string `json:"ticket_id"`
+    CodeHash         string `json:"code_hash"`
+    TicketCategoryID string `json:"ticket_category_id"`
+    Status           string `json:"status"`
+    AttendeeName     string `json:"attendee_name,omitempty"`
+}
+
+// GetEventManifest lets a gate device download everything it needs to admit
+// people offline: valid code hashes, categories and the signing public key.
+func GetEventManifest(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can download the manifest",
+        })
+    }
+
+    generatedAt := time.Now()
+
+    // Devices that already hold a manifest only fetch what changed since
+    query := config.DB.Where("event_id = ?", event.EventID)
+    if since := c.Query("since"); since != "" {
+        sinceTime, err := time.Parse(time.RFC3339, since)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "since must be an RFC3339 timestamp",
+            })
+        }
+        query = query.Where("updated_at > ?", sinceTime)
+    }
+
+    var tickets []models.Ticket
+    if err := query.Find(&tickets).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch tickets",
+        })
+    }
+
+    var ticketCategories []models.TicketCategory
+    if err := config.DB.Where("event_id = ?", event.EventID).Find(&ticketCategories).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch ticket categories",
+        })
+    }
+
+    // Names let gate staff check IDs while offline
+    var attendees []models.Attendee
+    if err := config.DB.Select("ticket_id", "name").Where("event_id = ? AND ticket_id IS NOT NULL", event.EventID).Find(&attendees).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch attendees",
+        })
+    }
+    attendeeNames := map[string]string{}
+    for _, attendee := range attendees {
+        attendeeNames[*attendee.TicketID] = attendee.Name
+    }
+
+    manifest := make([]manifestTicket, 0, len(tickets))
+    for _, ticket := range tickets {
+        manifest = append(manifest, manifestTicket{
+            TicketID:         ticket.TicketID,
+            CodeHash:         utils.HashToken(ticket.Code),
+            TicketCategoryID: ticket.TicketCategoryID,
+            Status:           ticket.Status,
+            AttendeeName:     attendeeNames[ticket.TicketID],
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "event_id":          event.EventID,
+        "generated_at":      generatedAt,
+        "public_key":        base64.StdEncoding.EncodeToString(utils.TicketSigningPublicKey()),
+        "code_hash":         "sha256 hex of the full ticket code",
+        "ticket_categories": ticketCategories,
+        "tickets":           manifest,
+    })
+}
+
+// SyncOfflineScans ingests scans a gate device made while offline. Scans are
+// applied in the order they happened, also across batches: a scan uploaded
+// late still wins over an admission that happened after it. Anything a device
+// decided differently from the server is reported as a conflict.
+func SyncOfflineScans(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can sync scans",
+        })
+    }
+
+    var req SyncScansRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    if req.Device == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Device is required",
+        })
+    }
+
+    if len(req.Scans) > maxSyncBatch {
+        return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+            "error": "Too many scans in one batch",
+        })
+    }
+
+    sort.SliceStable(req.Scans, func(i, j int) bool {
+        return req.Scans[i].ScannedAt.Before(req.Scans[j].ScannedAt)
+    })
+
+    now := time.Now()
+    results := make([]fiber.Map, 0, len(req.Scans))
+    conflicts := []fiber.Map{}
+    summary := map[string]int{}
+
+    for _, offline := range req.Scans {
+        if offline.DeviceScanID == "" || offline.Code == "" {
+            results = append(results, fiber.Map{
+                "device_scan_id": offline.DeviceScanID,
+                "outcome":        "rejected",
+                "message":        "Code and device_scan_id are required",
+            })
+            summary["rejected"]++
+            continue
+        }
+
+        var existing models.CheckIn
+        if config.DB.Where("device = ? AND device_scan_id = ?", req.Device, offline.DeviceScanID).First(&existing).Error == nil {
+            results = append(results, fiber.Map{
+                "device_scan_id": offline.DeviceScanID,
+                "outcome":        existing.Outcome,
+                "message":        "Already synced",
+            })
+            summary["already_synced"]++
+            continue
+        }
+
+        // Device clocks drift; never record a scan in the future
+        scannedAt := offline.ScannedAt
+        if scannedAt.IsZero() || scannedAt.After(now) {
+            scannedAt = now
+        }
+
+        deviceScanID := offline.DeviceScanID
+        checkIn, ticket, err := scanTicket(&event, scan{
+            Code:         offline.Code,
+            ScannedBy:    userID,
+            Gate:         offline.Gate,
+            Device:       req.Device,
+            DeviceScanID: &deviceScanID,
+            Offline:      true,
+            Direction:    offline.Direction,
+            ScannedAt:    scannedAt,
+        })
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "error":   "Failed to record scan " + offline.DeviceScanID,
+                "results": results,
+            })
+        }
+
+        results = append(results, fiber.Map{
+            "device_scan_id": offline.DeviceScanID,
+            "outcome":        checkIn.Outcome,
+            "message":        checkIn.Message,
+        })
+        summary[checkIn.Outcome]++
+
+        // The device let someone in that the server would not have. Devices
+        // that do not report a decision admit every correctly signed code.
+        deviceOutcome := offline.Outcome
+        if deviceOutcome == "" && checkIn.Direction == "in" {
+            deviceOutcome = "admitted"
+        }
+        // A scan synced earlier that happened after this one lost its
+        // admission to it; its device let someone in on a used ticket
+        if checkIn.Outcome == "admitted" && ticket != nil {
+            var superseded []models.CheckIn
+            if err := config.DB.Where("superseded_by = ?", checkIn.CheckInID).Find(&superseded).Error; err != nil {
+                return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                    "error":   "Failed to load scans superseded by " + offline.DeviceScanID,
+                    "results": results,
+                })
+            }
+            for _, later := range superseded {
+                conflicts = append(conflicts, fiber.Map{
+                    "device_scan_id":    later.DeviceScanID,
+                    "device":            later.Device,
+                    "gate":              later.Gate,
+                    "scanned_at":        later.ScannedAt,
+                    "outcome":           later.Outcome,
+                    "message":           later.Message,
+                    "ticket_id":         ticket.TicketID,
+                    "superseded":        true,
+                    "first_admitted_at": scannedAt,
+                    "first_gate":        offline.Gate,
+                    "first_device":      req.Device,
+                })
+            }
+        }
+
+        if deviceOutcome == "admitted" && checkIn.Outcome != "admitted" {
+            conflict := fiber.Map{
+                "device_scan_id": offline.DeviceScanID,
+                "gate":           offline.Gate,
+                "scanned_at":     scannedAt,
+                "outcome":        checkIn.Outcome,
+                "message":        checkIn.Message,
+            }
+            if ticket != nil {
+                conflict["ticket_id"] = ticket.TicketID
+                var first models.CheckIn
+                if checkIn.Outcome == "duplicate" && config.DB.
+                    Where("ticket_id = ? AND outcome = ? AND check_in_id <> ?", ticket.TicketID, "admitted", checkIn.CheckInID).
+                    Order("scanned_at").First(&first).Error == nil {
+                    conflict["first_admitted_at"] = first.ScannedAt
+                    conflict["first_gate"] = first.Gate
+                    conflict["first_device"] = first.Device
+                }
+            }
+            conflicts = append(conflicts, conflict)
+        }
+    }
+
+    return c.JSON(fiber.Map{
+        "message":   "Scans synced",
+        "processed": len(req.Scans),
+        "summary":   summary,
+        "conflicts": conflicts,
+        "results":   results,
+    })
+}
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// orderLine is one ticket category and how many tickets of it are bought,
+// or a single ticket bought from a resale listing.
+type orderLine struct {
+    TicketCategory models.TicketCategory
+    Quantity       int
+    Listing        *models.ResaleListing
+}
+
+// createOrder records an order waiting for payment together with its line
+// items. Inventory must already be reserved by the caller.
+func createOrder(tx *gorm.DB, userID string, lines []orderLine) (*models.Order, []models.OrderItem, error) {
+    expiresAt := time.Now().Add(config.PaymentTimeout())
+    order := models.Order{
+        UserID:    userID,
+        Status:    "pending_payment",
+        ExpiresAt: &expiresAt,
+    }
+
+    var items []models.OrderItem
+    for _, line := range lines {
+        item := models.OrderItem{
+            EventID:          line.TicketCategory.EventID,
+            TicketCategoryID: line.TicketCategory.TicketCategoryID,
+            Quantity:         line.Quantity,
+            UnitPrice:        line.TicketCategory.Price,
+        }
+        if line.Listing != nil {
+            item.Quantity = 1
+            item.UnitPrice = line.Listing.Price
+            item.ResaleListingID = &line.Listing.ListingID
+        }
+        item.Subtotal = item.UnitPrice * float64(item.Quantity)
+        items = append(items, item)
+        order.TotalAmount += item.Subtotal
+    }
+
+    if err := tx.Create(&order).Error; err != nil {
+        return nil, nil, err
+    }
+
+    for i := range items {
+        items[i].OrderID = order.OrderID
+    }
+    if err := tx.Create(&items).Error; err != nil {
+        return nil, nil, err
+    }
+
+    return &order, items, nil
+}
+
+// fulfillOrder marks an order as paid, mints its tickets, hands over resale
+// tickets and writes one transaction history row per event in the order.
+// Resale purchases get their own row with status resale.
+func fulfillOrder(tx *gorm.DB, order *models.Order, items []models.OrderItem) ([]models.Ticket, error) {
+    now := time.Now()
+
+    var minted, tickets []models.Ticket
+    eventTotals := map[string]*models.TransactionHistory{}
+    var eventOrder []string
+
+    for _, item := range items {
+        status := "completed"
+        if item.ResaleListingID != nil {
+            ticket, err := completeResale(tx, order, item)
+            if err != nil {
+                return nil, err
+            }
+            tickets = append(tickets, *ticket)
+            status = "resale"
+        } else {
+            start := len(minted)
+            for i := 0; i < item.Quantity; i++ {
+                ticket, err := newTicket(item.EventID, item.TicketCategoryID, order.UserID, order.OrderID)
+                if err != nil {
+                    return nil, err
+                }
+                minted = append(minted, ticket)
+            }
+            if err := assignAttendees(tx, item, minted[start:]); err != nil {
+                return nil, err
+            }
+        }
+
+        key := item.EventID + "/" + status
+        history, ok := eventTotals[key]
+        if !ok {
+            history = &models.TransactionHistory{
+                OwnerID:         order.UserID,
+                EventID:         item.EventID,
+                OrderID:         order.OrderID,
+                TransactionTime: now,
+                Status:          status,
+            }
+            eventTotals[key] = history
+            eventOrder = append(eventOrder, key)
+        }
+        history.Quantity += item.Quantity
+        history.TotalAmount += item.Subtotal
+    }
+
+    if len(minted) > 0 {
+        if err := tx.Create(&minted).Error; err != nil {
+            return nil, err
+        }
+        tickets = append(tickets, minted...)
+    }
+
+    for _, key := range eventOrder {
+        if err := tx.Create(eventTotals[key]).Error; err != nil {
+            return nil, err
+        }
+    }
+
+    if err := tx.Model(order).Updates(map[string]interface{}{
+        "status":  "paid",
+        "paid_at": now,
+    }).Error; err != nil {
+        return nil, err
+    }
+
+    return tickets, nil
+}
+
+func GetOrders(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var orders []models.Order
+    if err := config.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&orders).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch orders",
+        })
+    }
+
+    orderIDs := make([]string, 0, len(orders))
+    for _, order := range orders {
+        orderIDs = append(orderIDs, order.OrderID)
+    }
+
+    var items []models.OrderItem
+    if len(orderIDs) > 0 {
+        if err := config.DB.Where("order_id IN ?", orderIDs).Find(&items).Error; err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "error": "Failed to fetch order items",
+            })
+        }
+    }
+
+    itemsByOrder := map[string][]models.OrderItem{}
+    for _, item := range items {
+        itemsByOrder[item.OrderID] = append(itemsByOrder[item.OrderID], item)
+    }
+
+    result := make([]fiber.Map, 0, len(orders))
+    for _, order := range orders {
+        orderItems := itemsByOrder[order.OrderID]
+        if orderItems == nil {
+            orderItems = []models.OrderItem{}
+        }
+        result = append(result, fiber.Map{
+            "order": order,
+            "items": orderItems,
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "orders": result,
+    })
+}
+
+func GetOrder(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    orderID := c.Params("id")
+
+    var order models.Order
+    if err := config.DB.Where("order_id = ? AND user_id = ?", orderID, userID).First(&order).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Order not found",
+        })
+    }
+
+    var items []models.OrderItem
+    if err := config.DB.Where("order_id = ?", order.OrderID).Find(&items).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch order items",
+        })
+    }
+
+    var tickets []models.Ticket
+    if err := config.DB.Where("order_id = ?", order.OrderID).Find(&tickets).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch tickets",
+        })
+    }
+
+    var refunds []models.Refund
+    if err := config.DB.Where("order_id = ?", order.OrderID).Order("created_at").Find(&refunds).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch refunds",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "order":   order,
+        "items":   items,
+        "tickets": tickets,
+        "refunds": refunds,
+    })
+}
+
+func GetTransactionHistory(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var transactions []models.TransactionHistory
+    if err := config.DB.Where("owner_id = ?", userID).Order("transaction_time DESC").Find(&transactions).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch transactions",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "transactions": transactions,
+    })
+}
+
+func GetEventTransactions(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var transactions []models.TransactionHistory
+    if err := config.DB.Where("event_id = ?", event.EventID).Order("transaction_time DESC").Find(&transactions).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch transactions",
+        })
+    }
+
+    var totalSales float64
+    totalTickets := 0
+    for _, transaction := range transactions {
+        if transaction.Status == "completed" {
+            totalSales += transaction.TotalAmount
+            totalTickets += transaction.Quantity
+        }
+    }
+
+    return c.JSON(fiber.Map{
+        "transactions":  transactions,
+        "total_sales":   totalSales,
+        "total_tickets": totalTickets,
+    })
+}
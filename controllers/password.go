@@ -0,0 +1,199 @@
+package controllers
+
+import (
+    "errors"
+    "log"
+    "net/url"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+const (
+    passwordResetTTL      = time.Hour
+    passwordResetCooldown = time.Minute
+)
+
+type ForgotPasswordRequest struct {
+    Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+    Token    string `json:"token"`
+    Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+    CurrentPassword string `json:"current_password"`
+    NewPassword     string `json:"new_password"`
+}
+
+func validatePassword(password string) error {
+    if len(password) < 8 {
+        return errors.New("Password must be at least 8 characters")
+    }
+    return nil
+}
+
+func ForgotPassword(c *fiber.Ctx) error {
+    var req ForgotPasswordRequest
+    if err := c.BodyParser(&req); err != nil || req.Email == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Email is required",
+        })
+    }
+
+    // Always answer the same way so the endpoint cannot be used to find accounts
+    response := fiber.Map{
+        "message": "If the email is registered, a reset link has been sent",
+    }
+
+    var user models.User
+    if err := config.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+        return c.JSON(response)
+    }
+
+    // One email a minute, so the form cannot flood an inbox. Repeats get the
+    // same answer, so it does not tell registered addresses apart either
+    var recent int64
+    config.DB.Model(&models.UserToken{}).
+        Where("user_id = ? AND purpose = ? AND created_at > ?", user.UserID, "password_reset", time.Now().Add(-passwordResetCooldown)).
+        Count(&recent)
+    if recent > 0 {
+        return c.JSON(response)
+    }
+
+    token, err := issueUserToken(config.DB, user.UserID, "password_reset", passwordResetTTL)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to create reset token",
+        })
+    }
+
+    link := config.AppURL() + "/reset-password?token=" + url.QueryEscape(token)
+    if err := utils.Mail.Send(utils.Message{
+        To:      user.Email,
+        Subject: "Reset your password",
+        Body:    "Hi " + user.Name + ",\n\nUse the link below to reset your password. It expires in 1 hour and can only be used once.\n\n" + link + "\n\nIf you did not ask for this, you can ignore this email.",
+    }); err != nil {
+        log.Println("Failed to send password reset email:", err)
+    }
+
+    return c.JSON(response)
+}
+
+func ResetPassword(c *fiber.Ctx) error {
+    var req ResetPasswordRequest
+    if err := c.BodyParser(&req); err != nil || req.Token == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Token and password are required",
+        })
+    }
+
+    if err := validatePassword(req.Password); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": err.Error(),
+        })
+    }
+
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to hash password",
+        })
+    }
+
+    err = config.DB.Transaction(func(tx *gorm.DB) error {
+        userToken, err := consumeUserToken(tx, req.Token, "password_reset")
+        if err != nil {
+            return err
+        }
+
+        if err := tx.Model(&models.User{}).Where("user_id = ?", userToken.UserID).Update("password", string(hashedPassword)).Error; err != nil {
+            return err
+        }
+
+        // Whoever had the old password should not stay logged in
+        return revokeSessions(tx, userToken.UserID, "")
+    })
+
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Reset token is invalid or has expired",
+        })
+    }
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to reset password",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Password reset successfully",
+    })
+}
+
+func ChangePassword(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    sessionID := c.Locals("sessionID").(string)
+
+    var req ChangePasswordRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    var user models.User
+    if err := config.DB.Where("user_id = ?", userID).First(&user).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "User not found",
+        })
+    }
+
+    if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Current password is incorrect",
+        })
+    }
+
+    if err := validatePassword(req.NewPassword); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": err.Error(),
+        })
+    }
+
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to hash password",
+        })
+    }
+
+    err = config.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+            return err
+        }
+
+        // Keep the current session, log out everywhere else
+        var session models.Session
+        if err := tx.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+            return err
+        }
+        return revokeSessions(tx, userID, session.FamilyID)
+    })
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to change password",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Password changed successfully",
+    })
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// TestForgotPasswordCooldown asks for two reset emails in a row. Only the
+// first is sent, and both get the answer an unknown address gets.
+func TestForgotPasswordCooldown(t *testing.T) {
+    openTestDB(t)
+    mail := make(mailRecorder, 2)
+    utils.Mail = mail
+    app := fiber.New()
+    app.Post("/api/auth/forgot-password", ForgotPassword)
+
+    user := seedUser(t, "user")
+    body, _ := json.Marshal(ForgotPasswordRequest{Email: user.Email})
+    unknown, _ := json.Marshal(ForgotPasswordRequest{Email: "nobody@example.com"})
+
+    var want, got struct {
+        Message string `json:"message"`
+    }
+    if status := doJSON(t, app, http.MethodPost, "/api/auth/forgot-password", "", nil, unknown, &want); status != fiber.StatusOK {
+        t.Fatalf("unknown address: status %d", status)
+    }
+    for i := 0; i < 2; i++ {
+        if status := doJSON(t, app, http.MethodPost, "/api/auth/forgot-password", "", nil, body, &got); status != fiber.StatusOK || got.Message != want.Message {
+            t.Fatalf("request %d: status %d, message %q, want %q", i+1, status, got.Message, want.Message)
+        }
+    }
+
+    var tokens int64
+    config.DB.Model(&models.UserToken{}).Where("user_id = ? AND purpose = ?", user.UserID, "password_reset").Count(&tokens)
+    if tokens != 1 {
+        t.Errorf("%d reset tokens issued, want 1", tokens)
+    }
+    if len(mail) != 1 {
+        t.Errorf("%d reset emails sent, want 1", len(mail))
+    }
+}
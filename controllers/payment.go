@@ -0,0 +1,346 @@
+package controllers
+
+import (
+    "errors"
+    "log"
+    "math"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// startPayment asks the payment provider for a charge covering the order.
+func startPayment(order *models.Order) (*models.Payment, error) {
+    charge, err := utils.Payment.CreateCharge(order.OrderID, order.TotalAmount)
+    if err != nil {
+        return nil, err
+    }
+
+    payment := models.Payment{
+        OrderID:    order.OrderID,
+        Provider:   utils.Payment.Name(),
+        Reference:  charge.Reference,
+        Amount:     order.TotalAmount,
+        Status:     "pending",
+        PaymentURL: charge.PaymentURL,
+    }
+    if err := config.DB.Create(&payment).Error; err != nil {
+        return nil, err
+    }
+
+    return &payment, nil
+}
+
+// placeOrder starts the payment for a freshly created order. If no charge can
+// be created the order is cancelled so its tickets go back on sale.
+func placeOrder(order *models.Order) (*models.Payment, error) {
+    payment, err := startPayment(order)
+    if err != nil {
+        cancelUnchargedOrder(order.OrderID)
+        return nil, err
+    }
+    return payment, nil
+}
+
+// cancelUnchargedOrder cancels an order no charge could be created for.
+func cancelUnchargedOrder(orderID string) {
+    if err := config.DB.Transaction(func(tx *gorm.DB) error {
+        return closeUnpaidOrder(tx, orderID, "cancelled")
+    }); err != nil {
+        log.Println("Failed to cancel order", orderID+":", err)
+    }
+}
+
+// closeUnpaidOrder moves an order that is still waiting for payment to status
+// and releases its inventory. Orders in any other state are left alone. A
+// payment that is confirmed later is still processed, see
+// processPaymentNotification.
+func closeUnpaidOrder(tx *gorm.DB, orderID string, status string) error {
+    result := tx.Model(&models.Order{}).
+        Where("order_id = ? AND status = ?", orderID, "pending_payment").
+        Update("status", status)
+    if result.Error != nil || result.RowsAffected == 0 {
+        return result.Error
+    }
+
+    var items []models.OrderItem
+    if err := tx.Where("order_id = ?", orderID).Find(&items).Error; err != nil {
+        return err
+    }
+    for _, item := range items {
+        if err := releaseOrderItem(tx, item); err != nil {
+            return err
+        }
+    }
+
+    return tx.Model(&models.Payment{}).
+        Where("order_id = ? AND status = ?", orderID, "pending").
+        Update("status", status).Error
+}
+
+// processPaymentNotification applies a verified provider callback. Every
+// notification is stored first, so a duplicate delivery is a no-op.
+func processPaymentNotification(notification *utils.PaymentNotification, payload []byte) (string, error) {
+    if notification.NotificationID == "" || notification.Reference == "" {
+        return "", fiber.NewError(fiber.StatusBadRequest, "Notification ID and reference are required")
+    }
+
+    var refund *models.Refund
+    var outcome, paidOrderID string
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var payment models.Payment
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("reference = ?", notification.Reference).First(&payment).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Payment not found")
+        }
+
+        result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.PaymentNotification{
+            NotificationID: notification.NotificationID,
+            PaymentID:      payment.PaymentID,
+            Reference:      notification.Reference,
+            Status:         notification.Status,
+            Payload:        string(payload),
+        })
+        if result.Error != nil {
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            outcome = "duplicate"
+            return nil
+        }
+
+        // Closing an unpaid order closes its payment too, but the money can
+        // still arrive afterwards and must then buy the tickets or go back
+        latePayment := notification.Status == "paid" && (payment.Status == "expired" || payment.Status == "cancelled")
+        if payment.Status != "pending" && !latePayment {
+            outcome = "ignored"
+            return nil
+        }
+
+        switch notification.Status {
+        case "paid":
+            if math.Abs(notification.Amount-payment.Amount) > 0.005 {
+                return fiber.NewError(fiber.StatusBadRequest, "Paid amount does not match the order total")
+            }
+
+            now := time.Now()
+            if err := tx.Model(&payment).Updates(map[string]interface{}{
+                "status":  "paid",
+                "paid_at": now,
+            }).Error; err != nil {
+                return err
+            }
+
+            var order models.Order
+            if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ?", payment.OrderID).First(&order).Error; err != nil {
+                return err
+            }
+
+            var items []models.OrderItem
+            if err := tx.Where("order_id = ?", order.OrderID).Find(&items).Error; err != nil {
+                return err
+            }
+
+            if order.Status != "pending_payment" {
+                // The order expired before the money arrived. Take the
+                // tickets again if they are still there, otherwise refund.
+                if err := tx.Transaction(func(nested *gorm.DB) error {
+                    for _, item := range items {
+                        if err := reserveOrderItem(nested, item); err != nil {
+                            return err
+                        }
+                    }
+                    return nil
+                }); err != nil {
+                    if !errors.Is(err, ErrNotEnoughTickets) {
+                        return err
+                    }
+                    refund = &models.Refund{Amount: payment.Amount, Reason: "late_payment"}
+                    if err := queueRefund(tx, &payment, refund); err != nil {
+                        return err
+                    }
+                    outcome = "refunded"
+                    return nil
+                }
+            }
+
+            if _, err := fulfillOrder(tx, &order, items); err != nil {
+                return err
+            }
+            outcome = "paid"
+            paidOrderID = order.OrderID
+        case "failed", "expired":
+            if err := tx.Model(&payment).Update("status", notification.Status).Error; err != nil {
+                return err
+            }
+            if err := closeUnpaidOrder(tx, payment.OrderID, "cancelled"); err != nil {
+                return err
+            }
+            outcome = notification.Status
+        default:
+            outcome = "ignored"
+        }
+
+        return nil
+    })
+    if err != nil {
+        return "", err
+    }
+
+    // A refund that fails here is retried by the sweeper
+    if refund != nil {
+        if err := processRefund(refund.RefundID); err != nil {
+            log.Println("Failed to refund late payment", refund.RefundID+":", err)
+        }
+    }
+
+    // Rendering and mailing must not hold up the provider's callback
+    if outcome == "paid" {
+        go sendOrderConfirmation(paidOrderID)
+    }
+
+    return outcome, nil
+}
+
+func PaymentCallback(c *fiber.Ctx) error {
+    payload := c.Body()
+
+    notification, err := utils.Payment.ParseCallback(payload, c.Get("X-Payment-Signature"))
+    if err != nil {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Invalid payment notification",
+        })
+    }
+
+    outcome, err := processPaymentNotification(notification, payload)
+    if err != nil {
+        status := fiber.StatusInternalServerError
+        var fiberErr *fiber.Error
+        if errors.As(err, &fiberErr) {
+            status = fiberErr.Code
+        }
+        return c.Status(status).JSON(fiber.Map{
+            "error": "Failed to process payment notification: " + err.Error(),
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Payment notification processed",
+        "outcome": outcome,
+    })
+}
+
+// MockPayment completes or fails a mock charge and feeds the signed callback
+// through the normal webhook path. Only routed when the mock provider is used,
+// and only the buyer of the order can settle its charge.
+func MockPayment(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    mock, ok := utils.Payment.(*utils.MockPaymentProvider)
+    if !ok {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Mock payments are disabled",
+        })
+    }
+
+    var payment models.Payment
+    if err := config.DB.Where("reference = ? AND order_id IN (?)", c.Params("reference"),
+        config.DB.Model(&models.Order{}).Select("order_id").Where("user_id = ?", userID)).First(&payment).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Charge not found",
+        })
+    }
+
+    status := c.Query("status", "paid")
+    if status != "paid" && status != "failed" && status != "expired" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Status must be paid, failed or expired",
+        })
+    }
+
+    payload, signature, err := mock.Simulate(payment.Reference, status)
+    if err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Charge not found",
+        })
+    }
+
+    notification, err := mock.ParseCallback(payload, signature)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to sign mock notification",
+        })
+    }
+
+    outcome, err := processPaymentNotification(notification, payload)
+    if err != nil {
+        status := fiber.StatusInternalServerError
+        var fiberErr *fiber.Error
+        if errors.As(err, &fiberErr) {
+            status = fiberErr.Code
+        }
+        return c.Status(status).JSON(fiber.Map{
+            "error": "Failed to process payment notification: " + err.Error(),
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Mock payment processed",
+        "outcome": outcome,
+    })
+}
+
+func GetOrderPayment(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var order models.Order
+    if err := config.DB.Where("order_id = ? AND user_id = ?", c.Params("id"), userID).First(&order).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Order not found",
+        })
+    }
+
+    var payment models.Payment
+    if err := config.DB.Where("order_id = ?", order.OrderID).Order("created_at DESC").First(&payment).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Payment not found",
+        })
+    }
+
+    providerStatus, err := utils.Payment.QueryStatus(payment.Reference)
+    if err != nil {
+        providerStatus = "unknown"
+    }
+
+    return c.JSON(fiber.Map{
+        "order_status":    order.Status,
+        "payment":         payment,
+        "provider_status": providerStatus,
+    })
+}
+
+// ExpireUnpaidOrders closes orders whose payment window has passed.
+func ExpireUnpaidOrders() (int, error) {
+    var orders []models.Order
+    if err := config.DB.Where("status = ? AND expires_at <= ?", "pending_payment", time.Now()).Find(&orders).Error; err != nil {
+        return 0, err
+    }
+
+    expired := 0
+    for _, order := range orders {
+        err := config.DB.Transaction(func(tx *gorm.DB) error {
+            return closeUnpaidOrder(tx, order.OrderID, "expired")
+        })
+        if err != nil {
+            log.Println("Failed to expire order", order.OrderID+":", err)
+            continue
+        }
+        expired++
+    }
+
+    return expired, nil
+}
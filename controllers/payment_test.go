@@ -0,0 +1,419 @@
+package controllers
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// mailRecorder collects the mail handlers send, including mail sent after
+// the request already returned.
+type mailRecorder chan utils.Message
+
+func (m mailRecorder) Send(msg utils.Message) error {
+    m <- msg
+    return nil
+}
+
+// newPaymentTestApp routes the checkout and payment handlers. Requests act as
+// the user named in the X-Test-User header, standing in for the JWT middleware.
+func newPaymentTestApp() *fiber.App {
+    app := fiber.New()
+    app.Post("/api/payments/callback", PaymentCallback)
+    app.Post("/api/cart", testAuth, AddToCart)
+    app.Post("/api/checkout", testAuth, Checkout)
+    app.Post("/api/payments/mock/:reference", testAuth, MockPayment)
+    return app
+}
+
+func testAuth(c *fiber.Ctx) error {
+    if c.Get("X-Test-User") == "" {
+        return c.SendStatus(fiber.StatusUnauthorized)
+    }
+    c.Locals("userID", c.Get("X-Test-User"))
+    c.Locals("role", "user")
+    return c.Next()
+}
+
+// doJSON sends body to the app and decodes the JSON response into out. It
+// only reports errors, so it can be called from several goroutines.
+func doJSON(t *testing.T, app *fiber.App, method, path, userID string, headers map[string]string, body []byte, out interface{}) int {
+    t.Helper()
+
+    req := httptest.NewRequest(method, path, bytes.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    if userID != "" {
+        req.Header.Set("X-Test-User", userID)
+    }
+    for name, value := range headers {
+        req.Header.Set(name, value)
+    }
+
+    resp, err := app.Test(req, -1)
+    if err != nil {
+        t.Error(err)
+        return 0
+    }
+    defer resp.Body.Close()
+
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        t.Error(err)
+        return 0
+    }
+    if out != nil && len(data) > 0 {
+        if err := json.Unmarshal(data, out); err != nil {
+            t.Errorf("%s %s: %v in %s", method, path, err, data)
+        }
+    }
+    return resp.StatusCode
+}
+
+func TestPaymentCallbackRejectsBadSignature(t *testing.T) {
+    mock := utils.NewMockPaymentProvider([]byte("webhook-secret"))
+    utils.Payment = mock
+    app := newPaymentTestApp()
+
+    body := []byte(`{"notification_id":"n1","reference":"mock_1","status":"paid","amount":100}`)
+    forged := utils.NewMockPaymentProvider([]byte("another-secret"))
+    forgedCharge, _ := forged.CreateCharge("order", 100)
+    _, forgedSignature, _ := forged.Simulate(forgedCharge.Reference, "paid")
+
+    // A genuine signature, but for another notification
+    charge, _ := mock.CreateCharge("order", 100)
+    _, otherSignature, _ := mock.Simulate(charge.Reference, "paid")
+
+    for name, signature := range map[string]string{
+        "missing":    "",
+        "not hex":    "not-a-signature",
+        "wrong key":  forgedSignature,
+        "other body": otherSignature,
+    } {
+        t.Run(name, func(t *testing.T) {
+            var resp map[string]interface{}
+            status := doJSON(t, app, http.MethodPost, "/api/payments/callback", "", map[string]string{
+                "X-Payment-Signature": signature,
+            }, body, &resp)
+            if status != fiber.StatusUnauthorized {
+                t.Errorf("status = %d, want %d (%v)", status, fiber.StatusUnauthorized, resp)
+            }
+        })
+    }
+}
+
+// setupPayments loads a ticket signing key and installs the mock provider and
+// a mailer that only logs.
+func setupPayments(t *testing.T) *utils.MockPaymentProvider {
+    t.Helper()
+
+    t.Setenv("TICKET_SIGNING_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+    if err := utils.LoadTicketSigningKey(); err != nil {
+        t.Fatal(err)
+    }
+    mock := utils.NewMockPaymentProvider([]byte("webhook-secret"))
+    utils.Payment = mock
+    utils.Mail = utils.LogMailer{}
+    return mock
+}
+
+// checkoutTickets puts quantity tickets of a category in the buyer's cart and
+// checks out, returning the pending order and its payment.
+func checkoutTickets(t *testing.T, app *fiber.App, buyerID, categoryID string, quantity int) (models.Order, models.Payment) {
+    t.Helper()
+
+    body, _ := json.Marshal(AddToCartRequest{TicketCategoryID: categoryID, Quantity: quantity})
+    if status := doJSON(t, app, http.MethodPost, "/api/cart", buyerID, nil, body, nil); status != fiber.StatusOK {
+        t.Fatalf("add to cart: status %d", status)
+    }
+
+    var checkout struct {
+        Order   models.Order   `json:"order"`
+        Payment models.Payment `json:"payment"`
+    }
+    if status := doJSON(t, app, http.MethodPost, "/api/checkout", buyerID, nil, nil, &checkout); status != fiber.StatusOK {
+        t.Fatalf("checkout: status %d", status)
+    }
+    return checkout.Order, checkout.Payment
+}
+
+// expireOrder lets the payment window of an order run out and runs the
+// sweeper's expiry pass.
+func expireOrder(t *testing.T, orderID string) {
+    t.Helper()
+
+    config.DB.Model(&models.Order{}).Where("order_id = ?", orderID).Update("expires_at", time.Now().Add(-time.Minute))
+    if _, err := ExpireUnpaidOrders(); err != nil {
+        t.Fatal(err)
+    }
+
+    var order models.Order
+    config.DB.Where("order_id = ?", orderID).First(&order)
+    if order.Status != "expired" {
+        t.Fatalf("order status = %q after expiry, want expired", order.Status)
+    }
+}
+
+// TestLatePaymentAfterExpiry pays orders whose payment window ran out. The
+// tickets are taken again while they last, otherwise the payment is refunded.
+func TestLatePaymentAfterExpiry(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+    app := newPaymentTestApp()
+
+    var paid struct {
+        Outcome string `json:"outcome"`
+    }
+
+    t.Run("tickets left", func(t *testing.T) {
+        category := seedOnSaleCategory(t, 5, 100)
+        buyer := seedUser(t, "user")
+        order, payment := checkoutTickets(t, app, buyer.UserID, category.TicketCategoryID, 2)
+        expireOrder(t, order.OrderID)
+
+        if status := doJSON(t, app, http.MethodPost, "/api/payments/mock/"+payment.Reference, buyer.UserID, nil, nil, &paid); status != fiber.StatusOK || paid.Outcome != "paid" {
+            t.Fatalf("late payment: status %d, outcome %q, want paid", status, paid.Outcome)
+        }
+
+        var tickets int64
+        config.DB.Model(&models.Ticket{}).Where("order_id = ?", order.OrderID).Count(&tickets)
+        if tickets != 2 {
+            t.Errorf("%d tickets minted, want 2", tickets)
+        }
+        var after models.TicketCategory
+        config.DB.Where("ticket_category_id = ?", category.TicketCategoryID).First(&after)
+        if after.Sold != 2 {
+            t.Errorf("category sold %d, want 2", after.Sold)
+        }
+    })
+
+    t.Run("sold out", func(t *testing.T) {
+        category := seedOnSaleCategory(t, 2, 100)
+        buyer := seedUser(t, "user")
+        order, payment := checkoutTickets(t, app, buyer.UserID, category.TicketCategoryID, 2)
+        expireOrder(t, order.OrderID)
+
+        // Someone else bought the released tickets in the meantime
+        if err := reserveTickets(config.DB, category.TicketCategoryID, 2); err != nil {
+            t.Fatal(err)
+        }
+
+        if status := doJSON(t, app, http.MethodPost, "/api/payments/mock/"+payment.Reference, buyer.UserID, nil, nil, &paid); status != fiber.StatusOK || paid.Outcome != "refunded" {
+            t.Fatalf("late payment: status %d, outcome %q, want refunded", status, paid.Outcome)
+        }
+
+        var refund models.Refund
+        if err := config.DB.Where("order_id = ? AND reason = ?", order.OrderID, "late_payment").First(&refund).Error; err != nil {
+            t.Fatal("no late payment refund queued:", err)
+        }
+        if refund.Amount != 200 {
+            t.Errorf("refund of %.2f, want 200", refund.Amount)
+        }
+        var tickets int64
+        config.DB.Model(&models.Ticket{}).Where("order_id = ?", order.OrderID).Count(&tickets)
+        if tickets != 0 {
+            t.Errorf("%d tickets minted for a refunded payment", tickets)
+        }
+    })
+}
+
+// TestCheckoutMockPaymentMintsTickets buys two tickets through the cart and
+// pays for them with the mock provider.
+func TestCheckoutMockPaymentMintsTickets(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+    mail := make(mailRecorder, 1)
+    utils.Mail = mail
+    app := newPaymentTestApp()
+
+    category := seedOnSaleCategory(t, 10, 150)
+    buyer := seedUser(t, "user")
+    stranger := seedUser(t, "user")
+
+    addBody, _ := json.Marshal(AddToCartRequest{TicketCategoryID: category.TicketCategoryID, Quantity: 2})
+    if status := doJSON(t, app, http.MethodPost, "/api/cart", buyer.UserID, nil, addBody, nil); status != fiber.StatusOK {
+        t.Fatalf("add to cart: status %d", status)
+    }
+
+    var checkout struct {
+        Order   models.Order   `json:"order"`
+        Payment models.Payment `json:"payment"`
+    }
+    if status := doJSON(t, app, http.MethodPost, "/api/checkout", buyer.UserID, nil, nil, &checkout); status != fiber.StatusOK {
+        t.Fatalf("checkout: status %d", status)
+    }
+    if checkout.Order.Status != "pending_payment" || checkout.Payment.Amount != 300 {
+        t.Fatalf("checkout gave order %q for %.2f, want pending_payment for 300", checkout.Order.Status, checkout.Payment.Amount)
+    }
+
+    var tickets int64
+    config.DB.Model(&models.Ticket{}).Where("order_id = ?", checkout.Order.OrderID).Count(&tickets)
+    if tickets != 0 {
+        t.Fatalf("%d tickets minted before payment", tickets)
+    }
+
+    // Only the buyer can settle the charge
+    payPath := "/api/payments/mock/" + checkout.Payment.Reference
+    if status := doJSON(t, app, http.MethodPost, payPath, stranger.UserID, nil, nil, nil); status != fiber.StatusNotFound {
+        t.Errorf("another user paying: status %d, want %d", status, fiber.StatusNotFound)
+    }
+    if status := doJSON(t, app, http.MethodPost, payPath, "", nil, nil, nil); status != fiber.StatusUnauthorized {
+        t.Errorf("anonymous paying: status %d, want %d", status, fiber.StatusUnauthorized)
+    }
+
+    var paid struct {
+        Outcome string `json:"outcome"`
+    }
+    if status := doJSON(t, app, http.MethodPost, payPath, buyer.UserID, nil, nil, &paid); status != fiber.StatusOK || paid.Outcome != "paid" {
+        t.Fatalf("mock payment: status %d, outcome %q", status, paid.Outcome)
+    }
+
+    var order models.Order
+    if err := config.DB.Where("order_id = ?", checkout.Order.OrderID).First(&order).Error; err != nil {
+        t.Fatal(err)
+    }
+    if order.Status != "paid" {
+        t.Errorf("order status = %q, want paid", order.Status)
+    }
+
+    var minted []models.Ticket
+    config.DB.Where("order_id = ?", order.OrderID).Find(&minted)
+    if len(minted) != 2 {
+        t.Fatalf("%d tickets minted, want 2", len(minted))
+    }
+    for _, ticket := range minted {
+        if ticket.OwnerID != buyer.UserID || ticket.Status != "active" {
+            t.Errorf("ticket %s owned by %s with status %q", ticket.TicketID, ticket.OwnerID, ticket.Status)
+        }
+        if _, err := utils.VerifyTicketCode(ticket.Code); err != nil {
+            t.Errorf("ticket %s has an invalid code: %v", ticket.TicketID, err)
+        }
+    }
+
+    var after models.TicketCategory
+    config.DB.Where("ticket_category_id = ?", category.TicketCategoryID).First(&after)
+    if after.Sold != 2 || after.Held != 0 {
+        t.Errorf("category sold %d, held %d, want 2 sold and none held", after.Sold, after.Held)
+    }
+
+    // Paying the settled charge again changes nothing
+    if status := doJSON(t, app, http.MethodPost, payPath, buyer.UserID, nil, nil, &paid); status != fiber.StatusOK || paid.Outcome != "ignored" {
+        t.Errorf("paying twice: status %d, outcome %q, want ignored", status, paid.Outcome)
+    }
+    config.DB.Model(&models.Ticket{}).Where("order_id = ?", order.OrderID).Count(&tickets)
+    if tickets != 2 {
+        t.Errorf("%d tickets after paying twice, want 2", tickets)
+    }
+
+    select {
+    case msg := <-mail:
+        if msg.To != buyer.Email || len(msg.Attachments) != 1 {
+            t.Errorf("confirmation to %s with %d attachments", msg.To, len(msg.Attachments))
+        }
+    case <-time.After(10 * time.Second):
+        t.Error("no order confirmation sent")
+    }
+}
+
+// failingProvider refuses every charge, like a gateway that is down.
+type failingProvider struct {
+    *utils.MockPaymentProvider
+}
+
+func (failingProvider) CreateCharge(orderID string, amount float64) (*utils.Charge, error) {
+    return nil, errors.New("gateway unavailable")
+}
+
+// TestCheckoutKeepsCartWhenChargeFails checks that a buyer whose charge could
+// not be created still has the cart, with its tickets held, to try again.
+func TestCheckoutKeepsCartWhenChargeFails(t *testing.T) {
+    openTestDB(t)
+    mock := setupPayments(t)
+    app := newPaymentTestApp()
+
+    category := seedOnSaleCategory(t, 5, 100)
+    buyer := seedUser(t, "user")
+    body, _ := json.Marshal(AddToCartRequest{TicketCategoryID: category.TicketCategoryID, Quantity: 2})
+    if status := doJSON(t, app, http.MethodPost, "/api/cart", buyer.UserID, nil, body, nil); status != fiber.StatusOK {
+        t.Fatalf("add to cart: status %d", status)
+    }
+
+    utils.Payment = failingProvider{mock}
+    if status := doJSON(t, app, http.MethodPost, "/api/checkout", buyer.UserID, nil, nil, nil); status != fiber.StatusBadGateway {
+        t.Fatalf("checkout with the gateway down: status %d, want %d", status, fiber.StatusBadGateway)
+    }
+
+    var carts []models.Cart
+    config.DB.Where("user_id = ?", buyer.UserID).Find(&carts)
+    if len(carts) != 1 || carts[0].Quantity != 2 {
+        t.Fatalf("cart after failed charge = %+v, want the 2 tickets back", carts)
+    }
+    var after models.TicketCategory
+    config.DB.Where("ticket_category_id = ?", category.TicketCategoryID).First(&after)
+    if after.Held != 2 || after.Sold != 0 {
+        t.Errorf("category sold %d, held %d, want 2 held", after.Sold, after.Held)
+    }
+    var cancelled int64
+    config.DB.Model(&models.Order{}).Where("user_id = ? AND status = ?", buyer.UserID, "cancelled").Count(&cancelled)
+    if cancelled != 1 {
+        t.Errorf("%d cancelled orders, want the uncharged one", cancelled)
+    }
+
+    utils.Payment = mock
+    var checkout struct {
+        Order models.Order `json:"order"`
+    }
+    if status := doJSON(t, app, http.MethodPost, "/api/checkout", buyer.UserID, nil, nil, &checkout); status != fiber.StatusOK {
+        t.Fatalf("checkout retry: status %d", status)
+    }
+    if checkout.Order.TotalAmount != 200 {
+        t.Errorf("retried order total = %.2f, want 200", checkout.Order.TotalAmount)
+    }
+}
+
+// TestPaymentCallbackDuplicateDelivery delivers the same notification twice,
+// as gateways do when they miss an acknowledgement.
+func TestPaymentCallbackDuplicateDelivery(t *testing.T) {
+    openTestDB(t)
+    mock := setupPayments(t)
+    app := newPaymentTestApp()
+
+    category := seedOnSaleCategory(t, 5, 100)
+    buyer := seedUser(t, "user")
+    order, payment := checkoutTickets(t, app, buyer.UserID, category.TicketCategoryID, 1)
+
+    body, signature, err := mock.Simulate(payment.Reference, "paid")
+    if err != nil {
+        t.Fatal(err)
+    }
+    headers := map[string]string{"X-Payment-Signature": signature}
+
+    for i, want := range []string{"paid", "duplicate"} {
+        var resp struct {
+            Outcome string `json:"outcome"`
+        }
+        if status := doJSON(t, app, http.MethodPost, "/api/payments/callback", "", headers, body, &resp); status != fiber.StatusOK || resp.Outcome != want {
+            t.Errorf("delivery %d: status %d, outcome %q, want %s", i+1, status, resp.Outcome, want)
+        }
+    }
+
+    var tickets, notifications int64
+    config.DB.Model(&models.Ticket{}).Where("order_id = ?", order.OrderID).Count(&tickets)
+    if tickets != 1 {
+        t.Errorf("%d tickets minted, want 1", tickets)
+    }
+    config.DB.Model(&models.PaymentNotification{}).Where("reference = ?", payment.Reference).Count(&notifications)
+    if notifications != 1 {
+        t.Errorf("%d notifications stored, want 1", notifications)
+    }
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+    "log"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// Refunds the provider keeps rejecting are left as failed for an admin to
+// look at.
+const maxRefundAttempts = 5
+
+// A refund still processing after this long was abandoned by a crash or a
+// failed booking, and is picked up again by the sweeper.
+const staleRefundClaim = 10 * time.Minute
+
+// refundClaimable selects refunds that can be sent now: queued or failed ones
+// with attempts left, and ones whose claim went stale.
+func refundClaimable(tx *gorm.DB) *gorm.DB {
+    return tx.Where("(status IN ? AND attempts < ?) OR (status = ? AND (claimed_at IS NULL OR claimed_at <= ?))",
+        []string{"pending", "failed"}, maxRefundAttempts, "processing", time.Now().Add(-staleRefundClaim))
+}
+
+// queueRefund records a refund against a paid payment. It is sent to the
+// provider by processRefund once the surrounding transaction has committed.
+func queueRefund(tx *gorm.DB, payment *models.Payment, refund *models.Refund) error {
+    refund.OrderID = payment.OrderID
+    refund.PaymentID = payment.PaymentID
+    refund.Status = "pending"
+    return tx.Create(refund).Error
+}
+
+// eventRefundDue is what an order still has to get back for the tickets of an
+// event, after refunds already queued for them.
+func eventRefundDue(tx *gorm.DB, orderID, eventID string) (float64, error) {
+    var paid, refunded float64
+    if err := tx.Model(&models.OrderItem{}).
+        Where("order_id = ? AND event_id = ?", orderID, eventID).
+        Select("COALESCE(SUM(subtotal), 0)").Scan(&paid).Error; err != nil {
+        return 0, err
+    }
+    if err := tx.Model(&models.Refund{}).
+        Where("order_id = ? AND event_id = ?", orderID, eventID).
+        Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error; err != nil {
+        return 0, err
+    }
+    return paid - refunded, nil
+}
+
+// processRefund sends a queued refund to the payment provider and books it on
+// the order. The refund is claimed first, so the sweeper and the request that
+// queued it never send it at the same time. The refund ID is the provider's
+// refund key and the provider reference is stored as soon as the provider
+// accepts, so a refund picked up again after a crash is not paid out twice.
+func processRefund(refundID string) error {
+    claim := refundClaimable(config.DB.Model(&models.Refund{}).Where("refund_id = ?", refundID)).
+        Updates(map[string]interface{}{
+            "status":     "processing",
+            "attempts":   gorm.Expr("attempts + 1"),
+            "claimed_at": time.Now(),
+        })
+    if claim.Error != nil || claim.RowsAffected == 0 {
+        return claim.Error
+    }
+
+    var refund models.Refund
+    if err := config.DB.Where("refund_id = ?", refundID).First(&refund).Error; err != nil {
+        return err
+    }
+
+    var payment models.Payment
+    if err := config.DB.Where("payment_id = ?", refund.PaymentID).First(&payment).Error; err != nil {
+        return err
+    }
+
+    // A refund the provider accepted before only has to be booked
+    if refund.ProviderReference == nil {
+        reference, err := utils.Payment.Refund(payment.Reference, refund.RefundID, refund.Amount)
+        if err != nil {
+            reason := err.Error()
+            config.DB.Model(&refund).Updates(map[string]interface{}{
+                "status":         "failed",
+                "failure_reason": reason,
+            })
+            return err
+        }
+        if err := config.DB.Model(&refund).Update("provider_reference", reference).Error; err != nil {
+            return err
+        }
+        refund.ProviderReference = &reference
+    }
+
+    return config.DB.Transaction(func(tx *gorm.DB) error {
+        // Booking twice would count the refund twice on the order
+        result := tx.Model(&models.Refund{}).
+            Where("refund_id = ? AND status = ?", refund.RefundID, "processing").
+            Updates(map[string]interface{}{
+                "status":         "completed",
+                "failure_reason": nil,
+                "processed_at":   time.Now(),
+            })
+        if result.Error != nil || result.RowsAffected == 0 {
+            return result.Error
+        }
+
+        var order models.Order
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ?", refund.OrderID).First(&order).Error; err != nil {
+            return err
+        }
+
+        refunded := order.RefundedAmount + refund.Amount
+        updates := map[string]interface{}{"refunded_amount": refunded}
+        if refunded >= order.TotalAmount-0.005 {
+            updates["status"] = "refunded"
+        }
+        if err := tx.Model(&order).Updates(updates).Error; err != nil {
+            return err
+        }
+
+        if refunded >= payment.Amount-0.005 {
+            return tx.Model(&payment).Update("status", "refunded").Error
+        }
+        return nil
+    })
+}
+
+// ProcessPendingRefunds retries refunds that were queued but not sent yet,
+// that the provider rejected, or that were left processing.
+func ProcessPendingRefunds() (int, error) {
+    var refunds []models.Refund
+    if err := refundClaimable(config.DB).Order("created_at").Find(&refunds).Error; err != nil {
+        return 0, err
+    }
+
+    processed := 0
+    for _, refund := range refunds {
+        if err := processRefund(refund.RefundID); err != nil {
+            log.Println("Failed to process refund", refund.RefundID+":", err)
+            continue
+        }
+        processed++
+    }
+
+    return processed, nil
+}
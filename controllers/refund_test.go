@@ -0,0 +1,120 @@
+package controllers
+
+import (
+    "net/http"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// countingProvider counts the refunds sent to the gateway.
+type countingProvider struct {
+    *utils.MockPaymentProvider
+    refunds int32
+}
+
+func (p *countingProvider) Refund(reference, refundKey string, amount float64) (string, error) {
+    atomic.AddInt32(&p.refunds, 1)
+    return p.MockPaymentProvider.Refund(reference, refundKey, amount)
+}
+
+// TestStaleProcessingRefundIsRetried leaves refunds processing as a crash
+// would and runs the sweeper. A refund the provider already accepted is only
+// booked, one it never saw is sent, and a fresh claim is left alone.
+func TestStaleProcessingRefundIsRetried(t *testing.T) {
+    openTestDB(t)
+    mock := setupPayments(t)
+    provider := &countingProvider{MockPaymentProvider: mock}
+    utils.Payment = provider
+    app := newPaymentTestApp()
+
+    // queueStuck pays an order and leaves a full refund of it processing
+    // since claimedAt
+    queueStuck := func(claimedAt time.Time) models.Refund {
+        category := seedOnSaleCategory(t, 5, 100)
+        buyer := seedUser(t, "user")
+        order, payment := checkoutTickets(t, app, buyer.UserID, category.TicketCategoryID, 1)
+        if status := doJSON(t, app, http.MethodPost, "/api/payments/mock/"+payment.Reference, buyer.UserID, nil, nil, nil); status != fiber.StatusOK {
+            t.Fatalf("mock payment: status %d", status)
+        }
+        config.DB.Where("order_id = ?", order.OrderID).First(&payment)
+
+        refund := models.Refund{Amount: 100, Reason: "test"}
+        if err := queueRefund(config.DB, &payment, &refund); err != nil {
+            t.Fatal(err)
+        }
+        config.DB.Model(&refund).Updates(map[string]interface{}{
+            "status":     "processing",
+            "attempts":   1,
+            "claimed_at": claimedAt,
+        })
+        return refund
+    }
+
+    stale := time.Now().Add(-2 * staleRefundClaim)
+    sent := queueStuck(stale)
+    reference, err := mock.Refund(paymentReference(t, sent), sent.RefundID, sent.Amount)
+    if err != nil {
+        t.Fatal(err)
+    }
+    config.DB.Model(&sent).Update("provider_reference", reference)
+
+    unsent := queueStuck(stale)
+    fresh := queueStuck(time.Now())
+
+    // The sweeper would pick up refunds of other tests too
+    for _, refund := range []models.Refund{sent, unsent, fresh} {
+        if err := processRefund(refund.RefundID); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if calls := atomic.LoadInt32(&provider.refunds); calls != 1 {
+        t.Errorf("provider asked for %d refunds, want 1", calls)
+    }
+
+    for _, want := range []struct {
+        refund models.Refund
+        status string
+    }{
+        {sent, "completed"},
+        {unsent, "completed"},
+        {fresh, "processing"},
+    } {
+        var after models.Refund
+        config.DB.Where("refund_id = ?", want.refund.RefundID).First(&after)
+        if after.Status != want.status {
+            t.Errorf("refund %s status = %q, want %q", after.RefundID, after.Status, want.status)
+        }
+        if want.status == "completed" && after.ProviderReference == nil {
+            t.Errorf("refund %s completed without a provider reference", after.RefundID)
+        }
+
+        var order models.Order
+        config.DB.Where("order_id = ?", after.OrderID).First(&order)
+        if want.status == "completed" && order.RefundedAmount != 100 {
+            t.Errorf("order %s refunded %.2f, want 100", order.OrderID, order.RefundedAmount)
+        }
+    }
+
+    var after models.Refund
+    config.DB.Where("refund_id = ?", sent.RefundID).First(&after)
+    if after.ProviderReference == nil || *after.ProviderReference != reference {
+        t.Errorf("refund sent before the crash has reference %v, want %s", after.ProviderReference, reference)
+    }
+}
+
+func paymentReference(t *testing.T, refund models.Refund) string {
+    t.Helper()
+
+    var payment models.Payment
+    if err := config.DB.Where("payment_id = ?", refund.PaymentID).First(&payment).Error; err != nil {
+        t.Fatal(err)
+    }
+    return payment.Reference
+}
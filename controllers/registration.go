@@ -0,0 +1,553 @@
+package controllers
+
+import (
+    "bytes"
+    "encoding/csv"
+    "regexp"
+    "sort"
+    "strings"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+type QuestionRequest struct {
+    TicketCategoryID *string  `json:"ticket_category_id"`
+    Label            string   `json:"label"`
+    Type             string   `json:"type"`
+    Options          []string `json:"options"`
+    Required         bool     `json:"required"`
+    Scope            string   `json:"scope"`
+    MaxLength        int      `json:"max_length"`
+    Pattern          string   `json:"pattern"`
+    Position         int      `json:"position"`
+}
+
+// AnswerRequest answers one question. Text and choice questions take value,
+// checkbox questions take values.
+type AnswerRequest struct {
+    QuestionID string   `json:"question_id"`
+    Value      string   `json:"value"`
+    Values     []string `json:"values"`
+}
+
+// validateQuestion checks a question definition and returns the question to
+// store, or a message explaining what is wrong with it.
+func validateQuestion(event *models.Event, req *QuestionRequest) (*models.RegistrationQuestion, string) {
+    label := strings.TrimSpace(req.Label)
+    if label == "" || len(label) > 255 {
+        return nil, "Label is required and must be at most 255 characters"
+    }
+
+    if req.Scope == "" {
+        req.Scope = "order"
+    }
+    if req.Scope != "order" && req.Scope != "attendee" {
+        return nil, "Scope must be order or attendee"
+    }
+
+    if req.TicketCategoryID != nil && *req.TicketCategoryID != "" {
+        var count int64
+        config.DB.Model(&models.TicketCategory{}).Where("ticket_category_id = ? AND event_id = ?", *req.TicketCategoryID, event.EventID).Count(&count)
+        if count == 0 {
+            return nil, "Ticket category not found"
+        }
+    } else {
+        req.TicketCategoryID = nil
+    }
+
+    options := []string{}
+    seen := map[string]bool{}
+    for _, option := range req.Options {
+        option = strings.TrimSpace(option)
+        if option == "" || seen[option] {
+            return nil, "Options must be unique and not empty"
+        }
+        seen[option] = true
+        options = append(options, option)
+    }
+
+    switch req.Type {
+    case "text":
+        if len(options) > 0 {
+            return nil, "Text questions do not have options"
+        }
+        if req.MaxLength < 0 {
+            return nil, "max_length cannot be negative"
+        }
+        if req.Pattern != "" {
+            if _, err := regexp.Compile(req.Pattern); err != nil {
+                return nil, "Pattern is not a valid regular expression"
+            }
+        }
+    case "choice":
+        if len(options) < 2 {
+            return nil, "Choice questions need at least two options"
+        }
+    case "checkbox":
+        // Without options a checkbox is a single tick box, e.g. a consent
+    default:
+        return nil, "Type must be text, choice or checkbox"
+    }
+
+    if req.Type != "text" && (req.MaxLength != 0 || req.Pattern != "") {
+        return nil, "max_length and pattern only apply to text questions"
+    }
+
+    return &models.RegistrationQuestion{
+        EventID:          event.EventID,
+        TicketCategoryID: req.TicketCategoryID,
+        Label:            label,
+        Type:             req.Type,
+        Options:          options,
+        Required:         req.Required,
+        Scope:            req.Scope,
+        MaxLength:        req.MaxLength,
+        Pattern:          req.Pattern,
+        Position:         req.Position,
+    }, ""
+}
+
+// registrationQuestions loads the questions of a scope that apply to tickets
+// of the given categories of an event.
+func registrationQuestions(tx *gorm.DB, eventID string, ticketCategoryIDs []string, scope string) ([]models.RegistrationQuestion, error) {
+    var questions []models.RegistrationQuestion
+    err := tx.Where("event_id = ? AND scope = ? AND (ticket_category_id IS NULL OR ticket_category_id IN ?)", eventID, scope, ticketCategoryIDs).
+        Order("position, created_at").
+        Find(&questions).Error
+    return questions, err
+}
+
+// validateAnswers checks answers against the questions being asked and
+// returns the answers to store. Answers to questions that are not asked are
+// rejected so typos in question IDs do not go unnoticed.
+func validateAnswers(questions []models.RegistrationQuestion, answers []AnswerRequest) ([]models.RegistrationAnswer, string) {
+    byQuestion := map[string]AnswerRequest{}
+    for _, answer := range answers {
+        byQuestion[answer.QuestionID] = answer
+    }
+
+    var result []models.RegistrationAnswer
+    for _, question := range questions {
+        answer, ok := byQuestion[question.QuestionID]
+        delete(byQuestion, question.QuestionID)
+
+        var values []string
+        if ok {
+            for _, value := range append([]string{answer.Value}, answer.Values...) {
+                if value = strings.TrimSpace(value); value != "" {
+                    values = append(values, value)
+                }
+            }
+        }
+
+        // An unticked box counts as no answer
+        if question.Type == "checkbox" && len(question.Options) == 0 && len(values) == 1 && values[0] == "false" {
+            values = nil
+        }
+
+        if len(values) == 0 {
+            if question.Required {
+                return nil, question.Label + " is required"
+            }
+            continue
+        }
+
+        switch question.Type {
+        case "text":
+            if len(values) > 1 {
+                return nil, question.Label + " takes a single answer"
+            }
+            if question.MaxLength > 0 && len(values[0]) > question.MaxLength {
+                return nil, question.Label + " is too long"
+            }
+            if len(values[0]) > 2000 {
+                return nil, question.Label + " is too long"
+            }
+            if question.Pattern != "" {
+                if pattern, err := regexp.Compile(question.Pattern); err == nil && !pattern.MatchString(values[0]) {
+                    return nil, question.Label + " is not in the expected format"
+                }
+            }
+        case "choice":
+            if len(values) > 1 || !containsString(question.Options, values[0]) {
+                return nil, question.Label + " must be one of the options"
+            }
+        case "checkbox":
+            if len(question.Options) == 0 {
+                if len(values) > 1 || values[0] != "true" {
+                    return nil, question.Label + " must be true or false"
+                }
+                break
+            }
+            seen := map[string]bool{}
+            for _, value := range values {
+                if seen[value] || !containsString(question.Options, value) {
+                    return nil, question.Label + " must only contain the listed options"
+                }
+                seen[value] = true
+            }
+        }
+
+        result = append(result, models.RegistrationAnswer{
+            QuestionID: question.QuestionID,
+            EventID:    question.EventID,
+            Values:     values,
+        })
+    }
+
+    if len(byQuestion) > 0 {
+        return nil, "Answer does not match a question asked for this order"
+    }
+
+    return result, ""
+}
+
+func containsString(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
+
+// saveOrderAnswers validates and stores the answers to order questions of
+// every event in the order. Resale purchases are not asked again.
+func saveOrderAnswers(tx *gorm.DB, orderID string, items []models.OrderItem, answers []AnswerRequest) error {
+    categoriesByEvent := map[string][]string{}
+    var eventIDs []string
+    for _, item := range items {
+        if item.ResaleListingID != nil {
+            continue
+        }
+        if _, ok := categoriesByEvent[item.EventID]; !ok {
+            eventIDs = append(eventIDs, item.EventID)
+        }
+        categoriesByEvent[item.EventID] = append(categoriesByEvent[item.EventID], item.TicketCategoryID)
+    }
+
+    var questions []models.RegistrationQuestion
+    for _, eventID := range eventIDs {
+        eventQuestions, err := registrationQuestions(tx, eventID, categoriesByEvent[eventID], "order")
+        if err != nil {
+            return err
+        }
+        questions = append(questions, eventQuestions...)
+    }
+
+    result, msg := validateAnswers(questions, answers)
+    if msg != "" {
+        return fiber.NewError(fiber.StatusBadRequest, msg)
+    }
+    if len(result) == 0 {
+        return nil
+    }
+
+    for i := range result {
+        result[i].OrderID = orderID
+    }
+    return tx.Create(&result).Error
+}
+
+// saveAttendeeAnswers replaces the answers an attendee gave to the attendee
+// questions of their ticket category.
+func saveAttendeeAnswers(tx *gorm.DB, attendee *models.Attendee, orderID string, answers []AnswerRequest) error {
+    questions, err := registrationQuestions(tx, attendee.EventID, []string{attendee.TicketCategoryID}, "attendee")
+    if err != nil {
+        return err
+    }
+
+    result, msg := validateAnswers(questions, answers)
+    if msg != "" {
+        return fiber.NewError(fiber.StatusBadRequest, "Attendee "+attendee.Name+": "+msg)
+    }
+
+    if err := tx.Where("attendee_id = ?", attendee.AttendeeID).Delete(&models.RegistrationAnswer{}).Error; err != nil {
+        return err
+    }
+    if len(result) == 0 {
+        return nil
+    }
+
+    for i := range result {
+        result[i].OrderID = orderID
+        result[i].AttendeeID = &attendee.AttendeeID
+    }
+    return tx.Create(&result).Error
+}
+
+func GetRegistrationQuestions(c *fiber.Ctx) error {
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    var questions []models.RegistrationQuestion
+    if err := config.DB.Where("event_id = ?", event.EventID).Order("position, created_at").Find(&questions).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch questions",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "questions": questions,
+    })
+}
+
+func CreateRegistrationQuestion(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var req QuestionRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    question, msg := validateQuestion(event, &req)
+    if msg != "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": msg,
+        })
+    }
+
+    if err := config.DB.Create(question).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to create question",
+        })
+    }
+
+    return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+        "message":  "Question created successfully",
+        "question": question,
+    })
+}
+
+func UpdateRegistrationQuestion(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var existing models.RegistrationQuestion
+    if err := config.DB.Where("question_id = ? AND event_id = ?", c.Params("questionId"), event.EventID).First(&existing).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Question not found",
+        })
+    }
+
+    var req QuestionRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    question, msg := validateQuestion(event, &req)
+    if msg != "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": msg,
+        })
+    }
+
+    // Answers already given must keep making sense
+    var answers int64
+    config.DB.Model(&models.RegistrationAnswer{}).Where("question_id = ?", existing.QuestionID).Count(&answers)
+    if answers > 0 && (question.Type != existing.Type || question.Scope != existing.Scope) {
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+            "error": "Type and scope cannot be changed once the question has answers",
+        })
+    }
+
+    question.QuestionID = existing.QuestionID
+    question.CreatedAt = existing.CreatedAt
+    if err := config.DB.Save(question).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to update question",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message":  "Question updated successfully",
+        "question": question,
+    })
+}
+
+func DeleteRegistrationQuestion(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var question models.RegistrationQuestion
+    if err := config.DB.Where("question_id = ? AND event_id = ?", c.Params("questionId"), event.EventID).First(&question).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Question not found",
+        })
+    }
+
+    var answers int64
+    config.DB.Model(&models.RegistrationAnswer{}).Where("question_id = ?", question.QuestionID).Count(&answers)
+    if answers > 0 {
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+            "error": "Question already has answers and cannot be deleted",
+        })
+    }
+
+    if err := config.DB.Delete(&question).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to delete question",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Question deleted successfully",
+    })
+}
+
+// csvCell stops spreadsheets from running buyer input as a formula when the
+// export is opened.
+func csvCell(value string) string {
+    if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+        return "'" + value
+    }
+    return value
+}
+
+// ExportRegistrations returns a CSV with one row per ticket of the event: the
+// buyer, the attendee and one column per registration question.
+func ExportRegistrations(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var questions []models.RegistrationQuestion
+    if err := config.DB.Where("event_id = ?", event.EventID).Order("position, created_at").Find(&questions).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to export registrations",
+        })
+    }
+
+    var tickets []models.Ticket
+    if err := config.DB.Where("event_id = ?", event.EventID).Order("created_at").Find(&tickets).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to export registrations",
+        })
+    }
+
+    var ticketCategories []models.TicketCategory
+    if err := config.DB.Where("event_id = ?", event.EventID).Find(&ticketCategories).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to export registrations",
+        })
+    }
+
+    var attendees []models.Attendee
+    if err := config.DB.Where("event_id = ? AND ticket_id IS NOT NULL", event.EventID).Find(&attendees).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to export registrations",
+        })
+    }
+
+    var answers []models.RegistrationAnswer
+    if err := config.DB.Where("event_id = ?", event.EventID).Find(&answers).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to export registrations",
+        })
+    }
+
+    categoryNames := map[string]string{}
+    for _, ticketCategory := range ticketCategories {
+        categoryNames[ticketCategory.TicketCategoryID] = ticketCategory.Name
+    }
+
+    attendeesByTicket := map[string]models.Attendee{}
+    for _, attendee := range attendees {
+        attendeesByTicket[*attendee.TicketID] = attendee
+    }
+
+    // Order answers are keyed by order, attendee answers by attendee
+    answerValues := map[string]string{}
+    for _, answer := range answers {
+        owner := answer.OrderID
+        if answer.AttendeeID != nil {
+            owner = *answer.AttendeeID
+        }
+        answerValues[owner+"/"+answer.QuestionID] = strings.Join(answer.Values, "; ")
+    }
+
+    ownerIDs := map[string]bool{}
+    for _, ticket := range tickets {
+        ownerIDs[ticket.OwnerID] = true
+    }
+    var userIDs []string
+    for userID := range ownerIDs {
+        userIDs = append(userIDs, userID)
+    }
+    users := map[string]models.User{}
+    if len(userIDs) > 0 {
+        var owners []models.User
+        config.DB.Select("user_id", "name", "email").Where("user_id IN ?", userIDs).Find(&owners)
+        for _, owner := range owners {
+            users[owner.UserID] = owner
+        }
+    }
+
+    var buf bytes.Buffer
+    w := csv.NewWriter(&buf)
+
+    header := []string{"ticket_id", "status", "ticket_category", "order_id", "holder_name", "holder_email",
+        "attendee_name", "attendee_email", "attendee_id_number", "attendee_fields"}
+    for _, question := range questions {
+        header = append(header, csvCell(question.Label))
+    }
+    w.Write(header)
+
+    for _, ticket := range tickets {
+        holder := users[ticket.OwnerID]
+        attendee, hasAttendee := attendeesByTicket[ticket.TicketID]
+
+        var fields []string
+        for label, value := range attendee.CustomFields {
+            fields = append(fields, label+": "+value)
+        }
+        sort.Strings(fields)
+
+        row := []string{ticket.TicketID, ticket.Status, categoryNames[ticket.TicketCategoryID], ticket.OrderID,
+            holder.Name, holder.Email, attendee.Name, attendee.Email, attendee.IDNumber, strings.Join(fields, "; ")}
+        for _, question := range questions {
+            owner := ticket.OrderID
+            if question.Scope == "attendee" {
+                owner = ""
+                if hasAttendee {
+                    owner = attendee.AttendeeID
+                }
+            }
+            row = append(row, answerValues[owner+"/"+question.QuestionID])
+        }
+        for i := range row {
+            row[i] = csvCell(row[i])
+        }
+        w.Write(row)
+    }
+
+    w.Flush()
+    if err := w.Error(); err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to export registrations",
+        })
+    }
+
+    c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+    c.Set(fiber.HeaderContentDisposition, `attachment; filename="registrations-`+event.EventID+`.csv"`)
+    return c.Send(buf.Bytes())
+}
@@ -0,0 +1,410 @@
+package controllers
+
+import (
+    "errors"
+    "math"
+    "strconv"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+type ListTicketForResaleRequest struct {
+    Price float64 `json:"price"`
+}
+
+// resaleMaxPrice is the highest price a ticket of the category may be resold
+// for: face value plus the markup the organizer allows.
+func resaleMaxPrice(event *models.Event, ticketCategory *models.TicketCategory) float64 {
+    return math.Round(ticketCategory.Price*(1+event.ResaleMaxMarkup/100)*100) / 100
+}
+
+// reserveListing ties an active listing to an order while the buyer pays.
+func reserveListing(tx *gorm.DB, listingID, orderID string) error {
+    result := tx.Model(&models.ResaleListing{}).
+        Where("listing_id = ? AND status = ?", listingID, "active").
+        Updates(map[string]interface{}{
+            "status":   "reserved",
+            "order_id": orderID,
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// holdListing keeps an active listing for the buyer whose cart it was added
+// to. Resale tickets are bought through the normal cart checkout.
+func holdListing(tx *gorm.DB, listingID string) error {
+    result := tx.Model(&models.ResaleListing{}).
+        Where("listing_id = ? AND status = ?", listingID, "active").
+        Update("status", "held")
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// releaseHeldListing puts a listing back on sale when it leaves a cart.
+func releaseHeldListing(tx *gorm.DB, listingID string) error {
+    return tx.Model(&models.ResaleListing{}).
+        Where("listing_id = ? AND status = ?", listingID, "held").
+        Update("status", "active").Error
+}
+
+// sellHeldListing ties a listing held in a cart to the order created for it
+// at checkout.
+func sellHeldListing(tx *gorm.DB, listingID, orderID string) error {
+    result := tx.Model(&models.ResaleListing{}).
+        Where("listing_id = ? AND status = ?", listingID, "held").
+        Updates(map[string]interface{}{
+            "status":   "reserved",
+            "order_id": orderID,
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// unsellHeldListing undoes sellHeldListing for an order that could not be
+// charged, so the listing stays in the buyer's cart.
+func unsellHeldListing(tx *gorm.DB, listingID, orderID string) error {
+    result := tx.Model(&models.ResaleListing{}).
+        Where("listing_id = ? AND status = ? AND order_id = ?", listingID, "reserved", orderID).
+        Updates(map[string]interface{}{
+            "status":   "held",
+            "order_id": nil,
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotEnoughTickets
+    }
+    return nil
+}
+
+// checkResaleListing checks that a buyer can still buy a listing: it is not
+// their own, the event is on sale and has not started, and the price still
+// meets the organizer's rules. Returns the listing's ticket category.
+func checkResaleListing(tx *gorm.DB, listing *models.ResaleListing, userID string) (*models.TicketCategory, error) {
+    if listing.SellerID == userID {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "You cannot buy your own listing")
+    }
+
+    var event models.Event
+    if err := tx.Where("event_id = ?", listing.EventID).First(&event).Error; err != nil {
+        return nil, fiber.NewError(fiber.StatusNotFound, "Event not found")
+    }
+    if event.Status != "published" {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "Event is not on sale")
+    }
+    if !event.DateStart.After(time.Now()) {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "Tickets cannot be resold once the event has started")
+    }
+
+    var ticketCategory models.TicketCategory
+    if err := tx.Where("ticket_category_id = ?", listing.TicketCategoryID).First(&ticketCategory).Error; err != nil {
+        return nil, fiber.NewError(fiber.StatusNotFound, "Ticket category not found")
+    }
+
+    // The organizer may have tightened the rules since the ticket was listed
+    if !event.ResaleEnabled || listing.Price > resaleMaxPrice(&event, &ticketCategory) {
+        return nil, fiber.NewError(fiber.StatusConflict, "This listing no longer meets the organizer's resale rules")
+    }
+
+    return &ticketCategory, nil
+}
+
+// releaseListing puts a listing back on sale when its order is not paid.
+func releaseListing(tx *gorm.DB, listingID, orderID string) error {
+    return tx.Model(&models.ResaleListing{}).
+        Where("listing_id = ? AND status = ? AND order_id = ?", listingID, "reserved", orderID).
+        Updates(map[string]interface{}{
+            "status":   "active",
+            "order_id": nil,
+        }).Error
+}
+
+// completeResale hands a paid resale ticket to the buyer with a fresh code,
+// closes the listing and credits the seller.
+func completeResale(tx *gorm.DB, order *models.Order, item models.OrderItem) (*models.Ticket, error) {
+    var listing models.ResaleListing
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("listing_id = ? AND status = ? AND order_id = ?", *item.ResaleListingID, "reserved", order.OrderID).
+        First(&listing).Error; err != nil {
+        return nil, err
+    }
+
+    var ticket models.Ticket
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("ticket_id = ?", listing.TicketID).First(&ticket).Error; err != nil {
+        return nil, err
+    }
+
+    if err := tx.Model(&models.Ticket{}).Where("ticket_id = ?", ticket.TicketID).Updates(map[string]interface{}{
+        "owner_id": order.UserID,
+        "order_id": order.OrderID,
+        "status":   "active",
+    }).Error; err != nil {
+        return nil, err
+    }
+    ticket.OwnerID = order.UserID
+    ticket.OrderID = order.OrderID
+    ticket.Status = "active"
+
+    if err := reissueTicketCode(tx, &ticket); err != nil {
+        return nil, err
+    }
+
+    if err := clearAttendee(tx, ticket.TicketID); err != nil {
+        return nil, err
+    }
+
+    now := time.Now()
+    if err := tx.Model(&listing).Updates(map[string]interface{}{
+        "status":  "sold",
+        "sold_at": now,
+    }).Error; err != nil {
+        return nil, err
+    }
+
+    if err := tx.Create(&models.SellerCredit{
+        UserID:    listing.SellerID,
+        ListingID: listing.ListingID,
+        OrderID:   order.OrderID,
+        Amount:    item.Subtotal,
+        Status:    "available",
+    }).Error; err != nil {
+        return nil, err
+    }
+
+    return &ticket, nil
+}
+
+func ListTicketForResale(c *fiber.Ctx) error {
+    ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    var req ListTicketForResaleRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    if req.Price < 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Price cannot be negative",
+        })
+    }
+
+    var listing models.ResaleListing
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var ticket models.Ticket
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("ticket_id = ? AND owner_id = ?", ticketID, userID).
+            First(&ticket).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Ticket not found")
+        }
+
+        if ticket.Status != "active" || ticket.CheckedInAt != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Only unused tickets can be listed for resale")
+        }
+
+        var event models.Event
+        if err := tx.Where("event_id = ?", ticket.EventID).First(&event).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Event not found")
+        }
+        if event.Status != "published" {
+            return fiber.NewError(fiber.StatusBadRequest, "Event is not on sale")
+        }
+        if !event.ResaleEnabled {
+            return fiber.NewError(fiber.StatusBadRequest, "The organizer does not allow resale for this event")
+        }
+        if !event.DateStart.After(time.Now()) {
+            return fiber.NewError(fiber.StatusBadRequest, "Tickets cannot be resold once the event has started")
+        }
+
+        var ticketCategory models.TicketCategory
+        if err := tx.Where("ticket_category_id = ?", ticket.TicketCategoryID).First(&ticketCategory).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Ticket category not found")
+        }
+
+        if maxPrice := resaleMaxPrice(&event, &ticketCategory); req.Price > maxPrice {
+            return fiber.NewError(fiber.StatusBadRequest, "Price cannot be higher than "+strconv.FormatFloat(maxPrice, 'f', 2, 64))
+        }
+
+        var pending int64
+        tx.Model(&models.TicketTransfer{}).
+            Where("ticket_id = ? AND status = ? AND expires_at > ?", ticket.TicketID, "pending", time.Now()).
+            Count(&pending)
+        if pending > 0 {
+            return fiber.NewError(fiber.StatusConflict, "Cancel the pending transfer before listing this ticket")
+        }
+
+        // A listed ticket cannot be scanned or transferred until it is sold or
+        // taken off the market
+        if err := tx.Model(&ticket).Update("status", "listed").Error; err != nil {
+            return err
+        }
+
+        listing = models.ResaleListing{
+            TicketID:         ticket.TicketID,
+            EventID:          ticket.EventID,
+            TicketCategoryID: ticket.TicketCategoryID,
+            SellerID:         userID,
+            Price:            req.Price,
+            Status:           "active",
+        }
+        return tx.Create(&listing).Error
+    })
+
+    if err != nil {
+        return resaleError(c, err)
+    }
+
+    return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+        "message": "Ticket listed for resale",
+        "listing": listing,
+    })
+}
+
+func CancelResaleListing(c *fiber.Ctx) error {
+    ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        var listing models.ResaleListing
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("ticket_id = ? AND seller_id = ? AND status IN ?", ticketID, userID, []string{"active", "held", "reserved"}).
+            First(&listing).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Listing not found")
+        }
+
+        if listing.Status == "held" {
+            return fiber.NewError(fiber.StatusConflict, "A buyer has this ticket in their cart, try again later")
+        }
+        if listing.Status == "reserved" {
+            return fiber.NewError(fiber.StatusConflict, "A buyer is paying for this ticket, try again later")
+        }
+
+        if err := tx.Model(&listing).Update("status", "cancelled").Error; err != nil {
+            return err
+        }
+
+        return tx.Model(&models.Ticket{}).
+            Where("ticket_id = ? AND status = ?", ticketID, "listed").
+            Update("status", "active").Error
+    })
+
+    if err != nil {
+        return resaleError(c, err)
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Listing cancelled, the ticket is yours to use again",
+    })
+}
+
+func GetEventResaleListings(c *fiber.Ctx) error {
+    eventID := c.Params("id")
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", eventID).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    type resaleOffer struct {
+        ListingID        string    `json:"listing_id"`
+        TicketCategoryID string    `json:"ticket_category_id"`
+        CategoryName     string    `json:"category_name"`
+        FaceValue        float64   `json:"face_value"`
+        Price            float64   `json:"price"`
+        ListedAt         time.Time `json:"listed_at"`
+    }
+
+    // Sellers and ticket IDs stay private
+    var offers []resaleOffer
+    if err := config.DB.Table("resale_listings").
+        Select("resale_listings.listing_id, resale_listings.ticket_category_id, ticket_categories.name AS category_name, ticket_categories.price AS face_value, resale_listings.price, resale_listings.created_at AS listed_at").
+        Joins("JOIN ticket_categories ON ticket_categories.ticket_category_id = resale_listings.ticket_category_id").
+        Where("resale_listings.event_id = ? AND resale_listings.status = ?", event.EventID, "active").
+        Order("resale_listings.price, resale_listings.created_at").
+        Scan(&offers).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch resale listings",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "resale_enabled": event.ResaleEnabled,
+        "listings":       offers,
+    })
+}
+
+func GetMyResaleListings(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var listings []models.ResaleListing
+    if err := config.DB.Where("seller_id = ?", userID).Order("created_at DESC").Find(&listings).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch listings",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "listings": listings,
+    })
+}
+
+func GetSellerCredits(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var credits []models.SellerCredit
+    if err := config.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&credits).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch credits",
+        })
+    }
+
+    var balance float64
+    for _, credit := range credits {
+        if credit.Status == "available" {
+            balance += credit.Amount
+        }
+    }
+
+    return c.JSON(fiber.Map{
+        "balance": balance,
+        "credits": credits,
+    })
+}
+
+func resaleError(c *fiber.Ctx, err error) error {
+    status := fiber.StatusInternalServerError
+    message := "Resale request failed"
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        status = fiberErr.Code
+        message = fiberErr.Message
+    }
+    return c.Status(status).JSON(fiber.Map{
+        "error": message,
+    })
+}
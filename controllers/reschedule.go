@@ -0,0 +1,227 @@
+package controllers
+
+import (
+    "errors"
+    "log"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+const rescheduleDateFormat = "Mon, 2 Jan 2006 15:04"
+
+// heldTicketStatuses are the tickets whose holders still expect to attend.
+var heldTicketStatuses = []string{"active", "listed"}
+
+// recordReschedule stores a reschedule when an update moves the date or venue
+// of an event that has tickets out. previous is the event before the update.
+// Returns nil when there is nothing to record.
+func recordReschedule(tx *gorm.DB, previous *models.Event, req *CreateEventRequest) (*models.EventReschedule, error) {
+    reschedule := models.EventReschedule{
+        EventID:           previous.EventID,
+        PreviousDateStart: previous.DateStart,
+        PreviousDateEnd:   previous.DateEnd,
+        PreviousLocation:  previous.Location,
+        DateStart:         previous.DateStart,
+        DateEnd:           previous.DateEnd,
+        Location:          previous.Location,
+    }
+    if !req.DateStart.IsZero() {
+        reschedule.DateStart = req.DateStart
+    }
+    if !req.DateEnd.IsZero() {
+        reschedule.DateEnd = req.DateEnd
+    }
+    if req.Location != "" {
+        reschedule.Location = req.Location
+    }
+
+    if reschedule.DateStart.Equal(previous.DateStart) && reschedule.DateEnd.Equal(previous.DateEnd) && reschedule.Location == previous.Location {
+        return nil, nil
+    }
+
+    var held int64
+    if err := tx.Model(&models.Ticket{}).Where("event_id = ? AND status IN ?", previous.EventID, heldTicketStatuses).Count(&held).Error; err != nil {
+        return nil, err
+    }
+    if held == 0 {
+        return nil, nil
+    }
+
+    // Holders have to decide before the event takes place
+    reschedule.RefundDeadline = time.Now().Add(config.RescheduleRefundWindow())
+    if reschedule.RefundDeadline.After(reschedule.DateStart) {
+        reschedule.RefundDeadline = reschedule.DateStart
+    }
+
+    if err := tx.Create(&reschedule).Error; err != nil {
+        return nil, err
+    }
+    return &reschedule, nil
+}
+
+// notifyReschedule tells every holder of the event what changed and until
+// when they can get their money back instead.
+func notifyReschedule(eventName string, reschedule *models.EventReschedule) {
+    var holderIDs []string
+    if err := config.DB.Model(&models.Ticket{}).Distinct("owner_id").
+        Where("event_id = ? AND status IN ?", reschedule.EventID, heldTicketStatuses).
+        Pluck("owner_id", &holderIDs).Error; err != nil {
+        log.Println("Failed to load holders of rescheduled event", reschedule.EventID+":", err)
+        return
+    }
+
+    body := eventName + " has been changed by the organizer.\n\n" +
+        "Before: " + reschedule.PreviousDateStart.Format(rescheduleDateFormat) + " - " + reschedule.PreviousDateEnd.Format(rescheduleDateFormat) +
+        ", " + reschedule.PreviousLocation + "\n" +
+        "Now: " + reschedule.DateStart.Format(rescheduleDateFormat) + " - " + reschedule.DateEnd.Format(rescheduleDateFormat) +
+        ", " + reschedule.Location + "\n\n" +
+        "Your tickets stay valid for the new date. If you can no longer attend, you can give them up until " +
+        reschedule.RefundDeadline.Format(rescheduleDateFormat) + ". They are refunded to the payment method they were bought with:\n\n" +
+        config.AppURL() + "/tickets"
+
+    for _, holderID := range holderIDs {
+        notifyUser(holderID, "Event changed: "+eventName, body)
+    }
+}
+
+func GetEventReschedules(c *fiber.Ctx) error {
+    var event models.Event
+    if err := config.DB.Where("event_id = ? AND status IN ?", c.Params("id"), publicEventStatuses).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    var reschedules []models.EventReschedule
+    if err := config.DB.Where("event_id = ?", event.EventID).Order("created_at DESC").Find(&reschedules).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch reschedules",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "reschedules": reschedules,
+    })
+}
+
+// RequestRescheduleRefund gives up a ticket of a rescheduled event for a
+// refund of what was paid for it. The current holder asks, and the money goes
+// back to the payment method of the order the ticket was bought with, which
+// belongs to the buyer when the ticket was transferred.
+func RequestRescheduleRefund(c *fiber.Ctx) error {
+    ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    var refund *models.Refund
+    var ticket models.Ticket
+    var order models.Order
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("ticket_id = ? AND owner_id = ?", ticketID, userID).First(&ticket).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Ticket not found")
+        }
+
+        if ticket.Status != "active" || ticket.CheckedInAt != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Only unused tickets can be refunded")
+        }
+
+        if err := tx.Where("order_id = ?", ticket.OrderID).First(&order).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Order not found")
+        }
+
+        // Tickets ordered after the change were bought for the new date
+        var reschedule models.EventReschedule
+        if err := tx.Where("event_id = ? AND refund_deadline > ? AND created_at > ?", ticket.EventID, time.Now(), order.CreatedAt).
+            Order("created_at DESC").First(&reschedule).Error; err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "This ticket cannot be refunded")
+        }
+
+        var pending int64
+        tx.Model(&models.TicketTransfer{}).
+            Where("ticket_id = ? AND status = ? AND expires_at > ?", ticket.TicketID, "pending", time.Now()).
+            Count(&pending)
+        if pending > 0 {
+            return fiber.NewError(fiber.StatusConflict, "Cancel the pending transfer before asking for a refund")
+        }
+
+        var item models.OrderItem
+        if err := tx.Where("order_id = ? AND ticket_category_id = ?", order.OrderID, ticket.TicketCategoryID).First(&item).Error; err != nil {
+            return err
+        }
+
+        amount := item.UnitPrice
+        due, err := eventRefundDue(tx, order.OrderID, ticket.EventID)
+        if err != nil {
+            return err
+        }
+        if amount > due {
+            amount = due
+        }
+
+        if err := tx.Model(&ticket).Update("status", "refunded").Error; err != nil {
+            return err
+        }
+        if err := clearAttendee(tx, ticket.TicketID); err != nil {
+            return err
+        }
+        // The seat goes back on sale
+        if err := releaseTickets(tx, ticket.TicketCategoryID, 1); err != nil {
+            return err
+        }
+
+        // Free tickets are given up without a refund
+        if amount < 0.005 {
+            return nil
+        }
+
+        var payment models.Payment
+        if err := tx.Where("order_id = ? AND status = ?", order.OrderID, "paid").First(&payment).Error; err != nil {
+            return err
+        }
+
+        refund = &models.Refund{
+            EventID:  &ticket.EventID,
+            TicketID: &ticket.TicketID,
+            Amount:   amount,
+            Reason:   "reschedule",
+        }
+        return queueRefund(tx, &payment, refund)
+    })
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        return c.Status(fiberErr.Code).JSON(fiber.Map{
+            "error": fiberErr.Message,
+        })
+    }
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to refund ticket",
+        })
+    }
+
+    // A refund that fails here is retried by the sweeper
+    if refund != nil {
+        if err := processRefund(refund.RefundID); err != nil {
+            log.Println("Failed to process refund", refund.RefundID+":", err)
+        }
+        config.DB.Where("refund_id = ?", refund.RefundID).First(refund)
+
+        // The buyer of a transferred ticket learns where the money came from
+        if order.UserID != userID {
+            var event models.Event
+            config.DB.Where("event_id = ?", ticket.EventID).First(&event)
+            notifyUser(order.UserID, "Ticket refunded: "+event.Name,
+                "A ticket you bought for "+event.Name+" and passed on was given up after the event changed. "+
+                    "Its refund goes back to the payment method of your order "+order.OrderID+".")
+        }
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Ticket returned for a refund",
+        "refund":  refund,
+    })
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// TestTransferredTicketRescheduleRefund gives up a transferred ticket of a
+// rescheduled event. The holder asks and the buyer's payment is refunded.
+func TestTransferredTicketRescheduleRefund(t *testing.T) {
+    openTestDB(t)
+    setupPayments(t)
+    app := newPaymentTestApp()
+    app.Post("/api/tickets/:id/reschedule-refund", testAuth, RequestRescheduleRefund)
+
+    category := seedOnSaleCategory(t, 5, 100)
+    buyer := seedUser(t, "user")
+    holder := seedUser(t, "user")
+    order, payment := checkoutTickets(t, app, buyer.UserID, category.TicketCategoryID, 1)
+    if status := doJSON(t, app, http.MethodPost, "/api/payments/mock/"+payment.Reference, buyer.UserID, nil, nil, nil); status != fiber.StatusOK {
+        t.Fatalf("mock payment: status %d", status)
+    }
+
+    var ticket models.Ticket
+    config.DB.Where("order_id = ?", order.OrderID).First(&ticket)
+    config.DB.Model(&ticket).Update("owner_id", holder.UserID)
+
+    var event models.Event
+    config.DB.Where("event_id = ?", category.EventID).First(&event)
+    if err := config.DB.Create(&models.EventReschedule{
+        EventID:           event.EventID,
+        PreviousDateStart: event.DateStart,
+        PreviousDateEnd:   event.DateEnd,
+        PreviousLocation:  event.Location,
+        DateStart:         event.DateStart.Add(24 * time.Hour),
+        DateEnd:           event.DateEnd.Add(24 * time.Hour),
+        Location:          event.Location,
+        RefundDeadline:    time.Now().Add(24 * time.Hour),
+    }).Error; err != nil {
+        t.Fatal(err)
+    }
+
+    path := "/api/tickets/" + ticket.TicketID + "/reschedule-refund"
+    if status := doJSON(t, app, http.MethodPost, path, buyer.UserID, nil, nil, nil); status != fiber.StatusNotFound {
+        t.Errorf("buyer asking for a ticket they passed on: status %d, want %d", status, fiber.StatusNotFound)
+    }
+
+    var refunded struct {
+        Refund models.Refund `json:"refund"`
+    }
+    if status := doJSON(t, app, http.MethodPost, path, holder.UserID, nil, nil, &refunded); status != fiber.StatusOK {
+        t.Fatalf("holder asking for a refund: status %d", status)
+    }
+    if refunded.Refund.PaymentID != payment.PaymentID || refunded.Refund.Amount != 100 {
+        t.Errorf("refund of %.2f to payment %s, want 100 to the buyer's payment %s", refunded.Refund.Amount, refunded.Refund.PaymentID, payment.PaymentID)
+    }
+
+    var after models.Ticket
+    config.DB.Where("ticket_id = ?", ticket.TicketID).First(&after)
+    if after.Status != "refunded" {
+        t.Errorf("ticket status = %q, want refunded", after.Status)
+    }
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+type AssignStaffRequest struct {
+    User string `json:"user"`
+}
+
+// canScanEvent reports whether the user may check tickets in at the event:
+// admins, the event owner and staff assigned to it.
+func canScanEvent(userID, role string, event *models.Event) bool {
+    if role == "admin" || event.OwnerID == userID {
+        return true
+    }
+
+    var count int64
+    config.DB.Model(&models.EventStaff{}).Where("event_id = ? AND user_id = ?", event.EventID, userID).Count(&count)
+    return count > 0
+}
+
+func AssignEventStaff(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var req AssignStaffRequest
+    if err := c.BodyParser(&req); err != nil || req.User == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Email or username of the staff member is required",
+        })
+    }
+
+    var user models.User
+    if err := config.DB.Where("email = ? OR username = ?", req.User, req.User).First(&user).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "User not found",
+        })
+    }
+
+    var existing int64
+    config.DB.Model(&models.EventStaff{}).Where("event_id = ? AND user_id = ?", event.EventID, user.UserID).Count(&existing)
+    if existing > 0 {
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+            "error": "User is already staff for this event",
+        })
+    }
+
+    staff := models.EventStaff{
+        EventID:    event.EventID,
+        UserID:     user.UserID,
+        AssignedBy: event.OwnerID,
+    }
+    if err := config.DB.Create(&staff).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to assign staff",
+        })
+    }
+
+    return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+        "message": "Staff assigned successfully",
+        "staff":   staff,
+    })
+}
+
+func GetEventStaff(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    type staffMember struct {
+        EventStaffID string `json:"event_staff_id"`
+        UserID       string `json:"user_id"`
+        Username     string `json:"username"`
+        Name         string `json:"name"`
+        Email        string `json:"email"`
+    }
+
+    var staff []staffMember
+    if err := config.DB.Table("event_staffs").
+        Select("event_staffs.event_staff_id, users.user_id, users.username, users.name, users.email").
+        Joins("JOIN users ON users.user_id = event_staffs.user_id").
+        Where("event_staffs.event_id = ?", event.EventID).
+        Scan(&staff).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch staff",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "staff": staff,
+    })
+}
+
+func RemoveEventStaff(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    result := config.DB.Where("event_id = ? AND user_id = ?", event.EventID, c.Params("userId")).Delete(&models.EventStaff{})
+    if result.Error != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to remove staff",
+        })
+    }
+    if result.RowsAffected == 0 {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Staff member not found",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Staff removed successfully",
+    })
+}
+
+// GetStaffEvents lists the events the logged in user can scan tickets for.
+func GetStaffEvents(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var events []models.Event
+    if err := config.DB.
+        Where("event_id IN (?)", config.DB.Model(&models.EventStaff{}).Select("event_id").Where("user_id = ?", userID)).
+        Find(&events).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch events",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "events": events,
+    })
+}
@@ -1,16 +1,21 @@
 package controllers
 
 import (
+    "errors"
+    "time"
+
     "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
     "ticketing-backend/config"
     "ticketing-backend/models"
-    "github.com/google/uuid"
 )
 
 type CreateTicketRequest struct {
-    EventID          string `json:"event_id"`
-    TicketCategoryID string `json:"ticket_category_id"`
-    Quantity         int    `json:"quantity"`
+    EventID          string            `json:"event_id"`
+    TicketCategoryID string            `json:"ticket_category_id"`
+    Quantity         int               `json:"quantity"`
+    Attendees        []AttendeeRequest `json:"attendees"`
+    Answers          []AnswerRequest   `json:"answers"`
 }
 
 func CreateTicket(c *fiber.Ctx) error {
@@ -31,37 +36,75 @@ func CreateTicket(c *fiber.Ctx) error {
         })
     }
 
-    // Check quota
-    if ticketCategory.Sold+req.Quantity > ticketCategory.Quota {
+    if ticketCategory.Status == "retired" {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-            "error": "Not enough tickets available",
+            "error": "Ticket category is no longer on sale",
         })
     }
 
-    // Create tickets
-    var tickets []models.Ticket
-    for i := 0; i < req.Quantity; i++ {
-        ticket := models.Ticket{
-            EventID:          req.EventID,
-            TicketCategoryID: req.TicketCategoryID,
-            OwnerID:          userID,
-            Code:             uuid.New().String(),
-        }
-        tickets = append(tickets, ticket)
+    if !eventOnSale(config.DB, ticketCategory.EventID) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Event is not on sale",
+        })
     }
 
-    if err := config.DB.Create(&tickets).Error; err != nil {
+    if req.Quantity <= 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Quantity must be greater than 0",
+        })
+    }
+
+    // Check quota, update sold count and create the order in one transaction
+    var order *models.Order
+    var items []models.OrderItem
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        if err := reserveTickets(tx, req.TicketCategoryID, req.Quantity); err != nil {
+            return err
+        }
+
+        var err error
+        order, items, err = createOrder(tx, userID, []orderLine{{TicketCategory: ticketCategory, Quantity: req.Quantity}})
+        if err != nil {
+            return err
+        }
+
+        if err := saveOrderAttendees(tx, items, req.Attendees); err != nil {
+            return err
+        }
+
+        return saveOrderAnswers(tx, order.OrderID, items, req.Answers)
+    })
+
+    if errors.Is(err, ErrNotEnoughTickets) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Not enough tickets available",
+        })
+    }
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        return c.Status(fiberErr.Code).JSON(fiber.Map{
+            "error": fiberErr.Message,
+        })
+    }
+    if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to create tickets",
         })
     }
 
-    // Update sold count
-    config.DB.Model(&ticketCategory).Update("sold", ticketCategory.Sold+req.Quantity)
+    // Tickets are minted once the payment provider confirms the charge
+    payment, err := placeOrder(order)
+    if err != nil {
+        return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+            "error": "Failed to start payment",
+        })
+    }
 
     return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-        "message": "Tickets created successfully",
-        "tickets": tickets,
+        "message": "Order created, awaiting payment",
+        "order":   order,
+        "items":   items,
+        "payment": payment,
     })
 }
 
@@ -69,7 +112,7 @@ func GetTickets(c *fiber.Ctx) error {
     userID := c.Locals("userID").(string)
 
     var tickets []models.Ticket
-    if err := config.DB.Preload("Event").Preload("TicketCategory").Where("owner_id = ?", userID).Find(&tickets).Error; err != nil {
+    if err := config.DB.Where("owner_id = ?", userID).Find(&tickets).Error; err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to fetch tickets",
         })
@@ -85,19 +128,24 @@ func GetTicket(c *fiber.Ctx) error {
     userID := c.Locals("userID").(string)
 
     var ticket models.Ticket
-    if err := config.DB.Preload("Event").Preload("TicketCategory").Where("ticket_id = ? AND owner_id = ?", ticketID, userID).First(&ticket).Error; err != nil {
+    if err := config.DB.Where("ticket_id = ? AND owner_id = ?", ticketID, userID).First(&ticket).Error; err != nil {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
             "error": "Ticket not found",
         })
     }
 
-    return c.JSON(fiber.Map{
+    response := fiber.Map{
         "ticket": ticket,
-    })
+    }
+    attendeeDetails(response, &ticket)
+
+    return c.JSON(response)
 }
 
 func CheckInTicket(c *fiber.Ctx) error {
     ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
 
     var ticket models.Ticket
     if err := config.DB.Where("ticket_id = ?", ticketID).First(&ticket).Error; err != nil {
@@ -106,19 +154,67 @@ func CheckInTicket(c *fiber.Ctx) error {
         })
     }
 
-    if ticket.Status == "used" {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-            "error": "Ticket already used",
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", ticket.EventID).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    if !canScanEvent(userID, role, &event) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Only the event organizer or assigned staff can check in tickets",
         })
     }
 
-    if err := config.DB.Model(&ticket).Update("status", "used").Error; err != nil {
+    // Gate, device and direction are optional here
+    var req CheckInRequest
+    c.BodyParser(&req)
+
+    checkIn, scanned, err := scanTicket(&event, scan{
+        Code:      ticket.Code,
+        ScannedBy: userID,
+        Gate:      req.Gate,
+        Device:    req.Device,
+        Direction: req.Direction,
+        ScannedAt: time.Now(),
+    })
+    if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to check in ticket",
         })
     }
 
-    return c.JSON(fiber.Map{
-        "message": "Ticket checked in successfully",
-    })
-}
\ No newline at end of file
+    if checkIn.Outcome == "duplicate" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error":   "Ticket already used",
+            "outcome": checkIn.Outcome,
+            "message": checkIn.Message,
+        })
+    }
+    if checkIn.Outcome == "checked_out" {
+        return c.JSON(fiber.Map{
+            "message":        "Ticket checked out successfully",
+            "outcome":        checkIn.Outcome,
+            "checked_out_at": checkIn.ScannedAt,
+            "ticket":         scanned,
+        })
+    }
+    if checkIn.Outcome != "admitted" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error":   checkIn.Message,
+            "outcome": checkIn.Outcome,
+        })
+    }
+
+    response := fiber.Map{
+        "message":       "Ticket checked in successfully",
+        "outcome":       checkIn.Outcome,
+        "checked_in_by": userID,
+        "checked_in_at": checkIn.ScannedAt,
+        "ticket":        scanned,
+    }
+    attendeeDetails(response, scanned)
+
+    return c.JSON(response)
+}
@@ -0,0 +1,411 @@
+package controllers
+
+import (
+    "encoding/json"
+    "errors"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "time"
+)
+
+type CreateTicketCategoryRequest struct {
+    Name             string     `json:"name"`
+    Price            float64    `json:"price"`
+    Quota            int        `json:"quota"`
+    Description      string     `json:"description"`
+    DateStart        time.Time  `json:"date_start"`
+    DateEnd          time.Time  `json:"date_end"`
+    AdmissionPolicy  string     `json:"admission_policy"`
+    MaxEntries       int        `json:"max_entries"`
+    TransferDisabled bool       `json:"transfer_disabled"`
+    RequireAttendee  bool       `json:"require_attendee"`
+    RequireIDNumber  bool       `json:"require_id_number"`
+    AttendeeFields   []string   `json:"attendee_fields"`
+    AttendeeCutoff   *time.Time `json:"attendee_cutoff"`
+}
+
+type UpdateTicketCategoryRequest struct {
+    Name             *string    `json:"name"`
+    Price            *float64   `json:"price"`
+    Quota            *int       `json:"quota"`
+    Description      *string    `json:"description"`
+    DateStart        *time.Time `json:"date_start"`
+    DateEnd          *time.Time `json:"date_end"`
+    AdmissionPolicy  *string    `json:"admission_policy"`
+    MaxEntries       *int       `json:"max_entries"`
+    TransferDisabled *bool      `json:"transfer_disabled"`
+    RequireAttendee  *bool      `json:"require_attendee"`
+    RequireIDNumber  *bool      `json:"require_id_number"`
+    AttendeeFields   *[]string  `json:"attendee_fields"`
+    AttendeeCutoff   *time.Time `json:"attendee_cutoff"`
+}
+
+// validateAdmissionPolicy checks how often a ticket may be scanned in:
+// single (once), reentry (again after a check-out scan), multi (up to
+// max_entries times) or per_day (once per calendar day). Reentry and per_day
+// tickets may cap their entries with max_entries too, 0 leaves them open
+// until the event ends.
+func validateAdmissionPolicy(policy string, maxEntries int) string {
+    switch policy {
+    case "single":
+        if maxEntries != 0 {
+            return "max_entries does not apply to the single admission policy"
+        }
+    case "reentry", "per_day":
+        if maxEntries < 0 {
+            return "max_entries cannot be negative"
+        }
+    case "multi":
+        if maxEntries < 1 {
+            return "max_entries must be at least 1 for the multi admission policy"
+        }
+    default:
+        return "admission_policy must be one of single, reentry, multi or per_day"
+    }
+    return ""
+}
+
+// findOwnedEvent loads the event from the :id param and makes sure it belongs
+// to the logged in EO.
+func findOwnedEvent(c *fiber.Ctx) (*models.Event, error) {
+    userID := c.Locals("userID").(string)
+
+    var event models.Event
+    if err := config.DB.Where("event_id = ?", c.Params("id")).First(&event).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, fiber.NewError(fiber.StatusNotFound, "Event not found")
+        }
+        return nil, err
+    }
+
+    if event.OwnerID != userID {
+        return nil, fiber.NewError(fiber.StatusForbidden, "You can only manage your own events")
+    }
+
+    return &event, nil
+}
+
+// eventLookupError renders an error returned by findOwnedEvent.
+func eventLookupError(c *fiber.Ctx, err error) error {
+    status := fiber.StatusInternalServerError
+    message := "Failed to load event"
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        status = fiberErr.Code
+        message = fiberErr.Message
+    }
+    return c.Status(status).JSON(fiber.Map{
+        "error": message,
+    })
+}
+
+func CreateTicketCategory(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var req CreateTicketCategoryRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    if req.Name == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Name is required",
+        })
+    }
+
+    if req.Price < 0 || req.Quota <= 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Price cannot be negative and quota must be greater than 0",
+        })
+    }
+
+    if req.DateStart.IsZero() || req.DateEnd.IsZero() || !req.DateEnd.After(req.DateStart) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Sales window must have a start date before its end date",
+        })
+    }
+
+    if req.AdmissionPolicy == "" {
+        req.AdmissionPolicy = "single"
+    }
+    if msg := validateAdmissionPolicy(req.AdmissionPolicy, req.MaxEntries); msg != "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": msg,
+        })
+    }
+
+    attendeeFields, msg := validateAttendeeFields(req.AttendeeFields)
+    if msg != "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": msg,
+        })
+    }
+
+    ticketCategory := models.TicketCategory{
+        EventID:          event.EventID,
+        Name:             req.Name,
+        Price:            req.Price,
+        Quota:            req.Quota,
+        Description:      req.Description,
+        DateStart:        req.DateStart,
+        DateEnd:          req.DateEnd,
+        Status:           "active",
+        AdmissionPolicy:  req.AdmissionPolicy,
+        MaxEntries:       req.MaxEntries,
+        TransferDisabled: req.TransferDisabled,
+        RequireAttendee:  req.RequireAttendee,
+        RequireIDNumber:  req.RequireIDNumber,
+        AttendeeFields:   attendeeFields,
+        AttendeeCutoff:   req.AttendeeCutoff,
+    }
+
+    if err := config.DB.Create(&ticketCategory).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to create ticket category",
+        })
+    }
+
+    return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+        "message":         "Ticket category created successfully",
+        "ticket_category": ticketCategory,
+    })
+}
+
+func GetTicketCategories(c *fiber.Ctx) error {
+    eventID := c.Params("id")
+
+    // Categories of events under review are as hidden as the events themselves
+    var event models.Event
+    if err := config.DB.Where("event_id = ? AND status IN ?", eventID, publicEventStatuses).First(&event).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Event not found",
+        })
+    }
+
+    var ticketCategories []models.TicketCategory
+    if err := config.DB.Where("event_id = ?", eventID).Order("created_at").Find(&ticketCategories).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch ticket categories",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "ticket_categories": ticketCategories,
+    })
+}
+
+func UpdateTicketCategory(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var ticketCategory models.TicketCategory
+    if err := config.DB.Where("ticket_category_id = ? AND event_id = ?", c.Params("categoryId"), event.EventID).First(&ticketCategory).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Ticket category not found",
+        })
+    }
+
+    if ticketCategory.Status == "retired" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Retired ticket categories cannot be updated",
+        })
+    }
+
+    var req UpdateTicketCategoryRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Invalid request body",
+        })
+    }
+
+    updates := map[string]interface{}{}
+
+    if req.Name != nil {
+        if *req.Name == "" {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Name cannot be empty",
+            })
+        }
+        updates["name"] = *req.Name
+    }
+
+    if req.Description != nil {
+        updates["description"] = *req.Description
+    }
+
+    if req.Price != nil && *req.Price != ticketCategory.Price {
+        // Buyers already paid the old price, so it stays frozen after the first sale
+        if ticketCategory.Sold > 0 {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Price cannot be changed once tickets have been sold",
+            })
+        }
+        if *req.Price < 0 {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Price cannot be negative",
+            })
+        }
+        updates["price"] = *req.Price
+    }
+
+    if req.Quota != nil {
+        if *req.Quota <= 0 {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Quota must be greater than 0",
+            })
+        }
+        // Tickets held in carts are promised to their buyers as well
+        if *req.Quota < ticketCategory.Sold+ticketCategory.Held {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "Quota cannot be lower than the number of tickets already sold or held in carts",
+            })
+        }
+        updates["quota"] = *req.Quota
+    }
+
+    dateStart := ticketCategory.DateStart
+    dateEnd := ticketCategory.DateEnd
+    if req.DateStart != nil {
+        dateStart = *req.DateStart
+        updates["date_start"] = dateStart
+    }
+    if req.DateEnd != nil {
+        dateEnd = *req.DateEnd
+        updates["date_end"] = dateEnd
+    }
+    if !dateEnd.After(dateStart) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Sales window must have a start date before its end date",
+        })
+    }
+
+    // The policy can change at any time; scans already recorded still count
+    if req.AdmissionPolicy != nil || req.MaxEntries != nil {
+        policy := ticketCategory.AdmissionPolicy
+        maxEntries := ticketCategory.MaxEntries
+        if req.AdmissionPolicy != nil {
+            policy = *req.AdmissionPolicy
+            if policy != "multi" && req.MaxEntries == nil {
+                maxEntries = 0
+            }
+        }
+        if req.MaxEntries != nil {
+            maxEntries = *req.MaxEntries
+        }
+        if msg := validateAdmissionPolicy(policy, maxEntries); msg != "" {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": msg,
+            })
+        }
+        updates["admission_policy"] = policy
+        updates["max_entries"] = maxEntries
+    }
+
+    if req.TransferDisabled != nil {
+        updates["transfer_disabled"] = *req.TransferDisabled
+    }
+
+    if req.RequireAttendee != nil {
+        updates["require_attendee"] = *req.RequireAttendee
+    }
+    if req.RequireIDNumber != nil {
+        updates["require_id_number"] = *req.RequireIDNumber
+    }
+    if req.AttendeeFields != nil {
+        attendeeFields, msg := validateAttendeeFields(*req.AttendeeFields)
+        if msg != "" {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": msg,
+            })
+        }
+        // Map updates bypass the serializer, so store the encoded list
+        encoded, _ := json.Marshal(attendeeFields)
+        updates["attendee_fields"] = string(encoded)
+    }
+    if req.AttendeeCutoff != nil {
+        updates["attendee_cutoff"] = *req.AttendeeCutoff
+    }
+
+    if len(updates) == 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Nothing to update",
+        })
+    }
+
+    // Guard the quota against sales and holds that happened after we read the row
+    query := config.DB.Model(&models.TicketCategory{}).Where("ticket_category_id = ?", ticketCategory.TicketCategoryID)
+    if req.Quota != nil {
+        query = query.Where("sold + held <= ?", *req.Quota)
+    }
+    if _, ok := updates["price"]; ok {
+        query = query.Where("sold = 0")
+    }
+
+    result := query.Updates(updates)
+    if result.Error != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to update ticket category",
+        })
+    }
+    if result.RowsAffected == 0 {
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+            "error": "Ticket category was changed by a purchase, please try again",
+        })
+    }
+
+    config.DB.Where("ticket_category_id = ?", ticketCategory.TicketCategoryID).First(&ticketCategory)
+
+    return c.JSON(fiber.Map{
+        "message":         "Ticket category updated successfully",
+        "ticket_category": ticketCategory,
+    })
+}
+
+// DeleteTicketCategory removes a category that never sold anything. Categories
+// with sales are retired instead so existing tickets keep their category.
+func DeleteTicketCategory(c *fiber.Ctx) error {
+    event, err := findOwnedEvent(c)
+    if err != nil {
+        return eventLookupError(c, err)
+    }
+
+    var ticketCategory models.TicketCategory
+    if err := config.DB.Where("ticket_category_id = ? AND event_id = ?", c.Params("categoryId"), event.EventID).First(&ticketCategory).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Ticket category not found",
+        })
+    }
+
+    result := config.DB.Where("ticket_category_id = ? AND sold = 0", ticketCategory.TicketCategoryID).Delete(&models.TicketCategory{})
+    if result.Error != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to delete ticket category",
+        })
+    }
+    if result.RowsAffected > 0 {
+        config.DB.Where("ticket_category_id = ?", ticketCategory.TicketCategoryID).Delete(&models.Cart{})
+        return c.JSON(fiber.Map{
+            "message": "Ticket category deleted successfully",
+        })
+    }
+
+    if err := config.DB.Model(&ticketCategory).Update("status", "retired").Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to retire ticket category",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Ticket category retired successfully",
+    })
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+    "encoding/base64"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/google/uuid"
+    "github.com/skip2/go-qrcode"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+type VerifyTicketCodeRequest struct {
+    Code string `json:"code"`
+}
+
+// newTicket builds a ticket with its ID assigned up front so the signed code
+// can embed it.
+func newTicket(eventID, ticketCategoryID, ownerID, orderID string) (models.Ticket, error) {
+    ticket := models.Ticket{
+        TicketID:         uuid.New().String(),
+        EventID:          eventID,
+        TicketCategoryID: ticketCategoryID,
+        OwnerID:          ownerID,
+        OrderID:          orderID,
+    }
+
+    code, err := utils.SignTicketCode(utils.TicketCodePayload{
+        TicketID:         ticket.TicketID,
+        EventID:          eventID,
+        TicketCategoryID: ticketCategoryID,
+        IssuedAt:         time.Now(),
+    })
+    if err != nil {
+        return ticket, err
+    }
+    ticket.Code = code
+
+    return ticket, nil
+}
+
+// reissueTicketCode signs a fresh code for the ticket and stores it, which
+// invalidates every earlier copy of the QR code.
+func reissueTicketCode(tx *gorm.DB, ticket *models.Ticket) error {
+    code, err := utils.SignTicketCode(utils.TicketCodePayload{
+        TicketID:         ticket.TicketID,
+        EventID:          ticket.EventID,
+        TicketCategoryID: ticket.TicketCategoryID,
+        IssuedAt:         time.Now(),
+    })
+    if err != nil {
+        return err
+    }
+
+    if err := tx.Model(&models.Ticket{}).Where("ticket_id = ?", ticket.TicketID).Update("code", code).Error; err != nil {
+        return err
+    }
+    ticket.Code = code
+
+    return nil
+}
+
+// ReissueLegacyTicketCodes replaces the plain UUID codes of tickets minted
+// before codes were signed. It runs once, before the code_version column is
+// added, so a failure leaves the table to be tried again on the next start.
+func ReissueLegacyTicketCodes() (int, error) {
+    var tickets []models.Ticket
+    if err := config.DB.Where("code NOT LIKE ?", "T1.%").Find(&tickets).Error; err != nil {
+        return 0, err
+    }
+
+    reissued := 0
+    for _, ticket := range tickets {
+        code, err := utils.SignTicketCode(utils.TicketCodePayload{
+            TicketID:         ticket.TicketID,
+            EventID:          ticket.EventID,
+            TicketCategoryID: ticket.TicketCategoryID,
+            IssuedAt:         time.Now(),
+        })
+        if err != nil {
+            return reissued, err
+        }
+        if err := config.DB.Model(&models.Ticket{}).Where("ticket_id = ?", ticket.TicketID).Update("code", code).Error; err != nil {
+            return reissued, err
+        }
+        reissued++
+    }
+
+    return reissued, nil
+}
+
+func GetTicketQR(c *fiber.Ctx) error {
+    ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    var ticket models.Ticket
+    if err := config.DB.Where("ticket_id = ? AND owner_id = ?", ticketID, userID).First(&ticket).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Ticket not found",
+        })
+    }
+
+    size := c.QueryInt("size", 256)
+    if size < 128 || size > 1024 {
+        size = 256
+    }
+
+    png, err := qrcode.Encode(ticket.Code, qrcode.Medium, size)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to generate QR code",
+        })
+    }
+
+    c.Set(fiber.HeaderContentType, "image/png")
+    c.Set(fiber.HeaderCacheControl, "private, no-store")
+    return c.Send(png)
+}
+
+func VerifyTicketCode(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+    role := c.Locals("role").(string)
+
+    var req VerifyTicketCodeRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Code is required",
+        })
+    }
+
+    // Scanners may pass a trailing newline, the stored code has none
+    code := strings.TrimSpace(req.Code)
+    if code == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Code is required",
+        })
+    }
+
+    payload, err := utils.VerifyTicketCode(code)
+    if err != nil {
+        return c.JSON(fiber.Map{
+            "valid": false,
+            "error": "Signature is invalid",
+        })
+    }
+
+    response := fiber.Map{
+        "valid":   true,
+        "payload": payload,
+    }
+
+    // Only people who can scan the event learn the live ticket status
+    var event models.Event
+    if config.DB.Where("event_id = ?", payload.EventID).First(&event).Error == nil && canScanEvent(userID, role, &event) {
+        var ticket models.Ticket
+        if err := config.DB.Where("ticket_id = ?", payload.TicketID).First(&ticket).Error; err != nil {
+            response["status"] = "unknown"
+        } else if ticket.Code != code {
+            // The ticket was reissued, this is an old copy of the code
+            response["status"] = "superseded"
+        } else {
+            response["status"] = ticket.Status
+        }
+    }
+
+    return c.JSON(response)
+}
+
+func GetTicketPublicKey(c *fiber.Ctx) error {
+    publicKeyPEM, err := utils.TicketSigningPublicKeyPEM()
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to export public key",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "algorithm":  "Ed25519",
+        "public_key": base64.StdEncoding.EncodeToString(utils.TicketSigningPublicKey()),
+        "pem":        publicKeyPEM,
+        "format":     "T1.<base64url(version[1] | ticket_id[16] | event_id[16] | ticket_category_id[16] | issued_at_unix[8] | signature[64])>",
+    })
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+    "log"
+    "strconv"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// ticketPDFs gathers what is printed on the e-tickets. Tickets without
+// attendee details show the holder's name.
+func ticketPDFs(tickets []models.Ticket) ([]utils.TicketPDF, error) {
+    eventIDs := []string{}
+    categoryIDs := []string{}
+    ticketIDs := []string{}
+    ownerIDs := []string{}
+    for _, ticket := range tickets {
+        eventIDs = append(eventIDs, ticket.EventID)
+        categoryIDs = append(categoryIDs, ticket.TicketCategoryID)
+        ticketIDs = append(ticketIDs, ticket.TicketID)
+        ownerIDs = append(ownerIDs, ticket.OwnerID)
+    }
+
+    var events []models.Event
+    if err := config.DB.Where("event_id IN ?", eventIDs).Find(&events).Error; err != nil {
+        return nil, err
+    }
+    eventsByID := map[string]models.Event{}
+    for _, event := range events {
+        eventsByID[event.EventID] = event
+    }
+
+    var ticketCategories []models.TicketCategory
+    if err := config.DB.Where("ticket_category_id IN ?", categoryIDs).Find(&ticketCategories).Error; err != nil {
+        return nil, err
+    }
+    categoryNames := map[string]string{}
+    for _, ticketCategory := range ticketCategories {
+        categoryNames[ticketCategory.TicketCategoryID] = ticketCategory.Name
+    }
+
+    var attendees []models.Attendee
+    if err := config.DB.Where("ticket_id IN ?", ticketIDs).Find(&attendees).Error; err != nil {
+        return nil, err
+    }
+    attendeeNames := map[string]string{}
+    for _, attendee := range attendees {
+        attendeeNames[*attendee.TicketID] = attendee.Name
+    }
+
+    var owners []models.User
+    if err := config.DB.Select("user_id", "name").Where("user_id IN ?", ownerIDs).Find(&owners).Error; err != nil {
+        return nil, err
+    }
+    ownerNames := map[string]string{}
+    for _, owner := range owners {
+        ownerNames[owner.UserID] = owner.Name
+    }
+
+    pages := make([]utils.TicketPDF, 0, len(tickets))
+    for _, ticket := range tickets {
+        event := eventsByID[ticket.EventID]
+        page := utils.TicketPDF{
+            TicketID:     ticket.TicketID,
+            Code:         ticket.Code,
+            EventName:    event.Name,
+            DateStart:    event.DateStart,
+            DateEnd:      event.DateEnd,
+            Location:     event.Location,
+            Category:     categoryNames[ticket.TicketCategoryID],
+            AttendeeName: attendeeNames[ticket.TicketID],
+            IssuedAt:     ticket.CreatedAt,
+        }
+        if page.AttendeeName == "" {
+            page.AttendeeName = ownerNames[ticket.OwnerID]
+        }
+        if payload, err := utils.VerifyTicketCode(ticket.Code); err == nil {
+            page.IssuedAt = payload.IssuedAt
+        }
+        pages = append(pages, page)
+    }
+
+    return pages, nil
+}
+
+func sendPDF(c *fiber.Ctx, filename string, pdf []byte) error {
+    c.Set(fiber.HeaderContentType, "application/pdf")
+    c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+    c.Set(fiber.HeaderCacheControl, "private, no-store")
+    return c.Send(pdf)
+}
+
+// sendOrderConfirmation mails the buyer their e-tickets once an order is paid.
+func sendOrderConfirmation(orderID string) {
+    var order models.Order
+    if err := config.DB.Where("order_id = ?", orderID).First(&order).Error; err != nil {
+        return
+    }
+
+    var user models.User
+    if err := config.DB.Where("user_id = ?", order.UserID).First(&user).Error; err != nil {
+        return
+    }
+
+    var tickets []models.Ticket
+    if err := config.DB.Where("order_id = ? AND owner_id = ?", order.OrderID, order.UserID).Order("created_at").Find(&tickets).Error; err != nil || len(tickets) == 0 {
+        return
+    }
+
+    msg := utils.Message{
+        To:      user.Email,
+        Subject: "Your tickets",
+        Body: "Hi " + user.Name + ",\n\nThank you for your order. Your " + strconv.Itoa(len(tickets)) +
+            " ticket(s) are attached and can also be found in the app.\n\n" + config.AppURL() + "/orders/" + order.OrderID,
+    }
+
+    pages, err := ticketPDFs(tickets)
+    if err == nil {
+        var pdf []byte
+        pdf, err = utils.RenderTicketsPDF("Order "+order.OrderID, pages)
+        if err == nil {
+            msg.Attachments = []utils.Attachment{{
+                Filename:    "tickets-" + order.OrderID + ".pdf",
+                ContentType: "application/pdf",
+                Data:        pdf,
+            }}
+        }
+    }
+    // Still confirm the order when the PDF cannot be rendered
+    if err != nil {
+        log.Println("Failed to render tickets for order", order.OrderID+":", err)
+    }
+
+    if err := utils.Mail.Send(msg); err != nil {
+        log.Println("Failed to send order confirmation to", user.Email+":", err)
+    }
+}
+
+func GetTicketPDF(c *fiber.Ctx) error {
+    ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    var ticket models.Ticket
+    if err := config.DB.Where("ticket_id = ? AND owner_id = ?", ticketID, userID).First(&ticket).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Ticket not found",
+        })
+    }
+
+    pages, err := ticketPDFs([]models.Ticket{ticket})
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to load ticket details",
+        })
+    }
+
+    pdf, err := utils.RenderTicketsPDF("Ticket "+ticket.TicketID, pages)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to generate PDF",
+        })
+    }
+
+    return sendPDF(c, "ticket-"+ticket.TicketID+".pdf", pdf)
+}
+
+func GetOrderPDF(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var order models.Order
+    if err := config.DB.Where("order_id = ? AND user_id = ?", c.Params("id"), userID).First(&order).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Order not found",
+        })
+    }
+
+    // Tickets since transferred or resold are no longer the buyer's to print
+    var tickets []models.Ticket
+    if err := config.DB.Where("order_id = ? AND owner_id = ?", order.OrderID, userID).Order("created_at").Find(&tickets).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch tickets",
+        })
+    }
+
+    if len(tickets) == 0 {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "This order has no tickets yet",
+        })
+    }
+
+    pages, err := ticketPDFs(tickets)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to load ticket details",
+        })
+    }
+
+    pdf, err := utils.RenderTicketsPDF("Order "+order.OrderID, pages)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to generate PDF",
+        })
+    }
+
+    return sendPDF(c, "tickets-"+order.OrderID+".pdf", pdf)
+}
@@ -0,0 +1,309 @@
+package controllers
+
+import (
+    "errors"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+const ticketTransferTTL = 7 * 24 * time.Hour
+
+type TransferTicketRequest struct {
+    Recipient string `json:"recipient"`
+}
+
+// transferBlocked explains why a ticket cannot change hands, or returns an
+// empty string when it can.
+func transferBlocked(tx *gorm.DB, ticket *models.Ticket) (string, *models.Event) {
+    if ticket.Status != "active" {
+        return "Only active tickets can be transferred", nil
+    }
+    if ticket.CheckedInAt != nil {
+        return "Tickets that have been checked in cannot be transferred", nil
+    }
+
+    var event models.Event
+    if err := tx.Where("event_id = ?", ticket.EventID).First(&event).Error; err != nil {
+        return "Event not found", nil
+    }
+    if event.TransferDisabled {
+        return "The organizer does not allow ticket transfers for this event", &event
+    }
+    if !event.DateEnd.After(time.Now()) {
+        return "The event has already ended", &event
+    }
+
+    var ticketCategory models.TicketCategory
+    if err := tx.Where("ticket_category_id = ?", ticket.TicketCategoryID).First(&ticketCategory).Error; err == nil && ticketCategory.TransferDisabled {
+        return "The organizer does not allow transfers for this ticket category", &event
+    }
+
+    return "", &event
+}
+
+func TransferTicket(c *fiber.Ctx) error {
+    ticketID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    var req TransferTicketRequest
+    if err := c.BodyParser(&req); err != nil || req.Recipient == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Email or username of the recipient is required",
+        })
+    }
+
+    var recipient models.User
+    if err := config.DB.Where("email = ? OR username = ?", req.Recipient, req.Recipient).First(&recipient).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "Recipient not found",
+        })
+    }
+
+    if recipient.UserID == userID {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "You cannot transfer a ticket to yourself",
+        })
+    }
+
+    var transfer models.TicketTransfer
+    var event *models.Event
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        // Lock the ticket so two transfers of it cannot be started at once
+        var ticket models.Ticket
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("ticket_id = ? AND owner_id = ?", ticketID, userID).
+            First(&ticket).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Ticket not found")
+        }
+
+        var reason string
+        reason, event = transferBlocked(tx, &ticket)
+        if reason != "" {
+            return fiber.NewError(fiber.StatusBadRequest, reason)
+        }
+
+        var pending int64
+        tx.Model(&models.TicketTransfer{}).
+            Where("ticket_id = ? AND status = ? AND expires_at > ?", ticketID, "pending", time.Now()).
+            Count(&pending)
+        if pending > 0 {
+            return fiber.NewError(fiber.StatusConflict, "This ticket already has a pending transfer")
+        }
+
+        // An offer cannot outlive the event
+        expiresAt := time.Now().Add(ticketTransferTTL)
+        if event.DateEnd.Before(expiresAt) {
+            expiresAt = event.DateEnd
+        }
+
+        transfer = models.TicketTransfer{
+            TicketID:   ticket.TicketID,
+            EventID:    ticket.EventID,
+            FromUserID: userID,
+            ToUserID:   recipient.UserID,
+            Status:     "pending",
+            ExpiresAt:  expiresAt,
+        }
+        return tx.Create(&transfer).Error
+    })
+
+    if err != nil {
+        return transferError(c, err)
+    }
+
+    notifyUser(recipient.UserID, "A ticket was sent to you",
+        "Someone wants to send you a ticket for "+event.Name+". Accept it in the app before "+
+            transfer.ExpiresAt.Format("2 Jan 2006 15:04")+".\n\n"+config.AppURL()+"/transfers")
+
+    return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+        "message":  "Transfer started, waiting for the recipient to accept",
+        "transfer": transfer,
+    })
+}
+
+func GetTransfers(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    query := config.DB.Model(&models.TicketTransfer{})
+    switch c.Query("direction") {
+    case "incoming":
+        query = query.Where("to_user_id = ?", userID)
+    case "outgoing":
+        query = query.Where("from_user_id = ?", userID)
+    default:
+        query = query.Where("to_user_id = ? OR from_user_id = ?", userID, userID)
+    }
+    if status := c.Query("status"); status != "" {
+        query = query.Where("status = ?", status)
+    }
+
+    var transfers []models.TicketTransfer
+    if err := query.Order("created_at DESC").Find(&transfers).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to fetch transfers",
+        })
+    }
+
+    // Offers nobody answered in time are reported as expired
+    now := time.Now()
+    for i := range transfers {
+        if transfers[i].Status == "pending" && !transfers[i].ExpiresAt.After(now) {
+            transfers[i].Status = "expired"
+        }
+    }
+
+    return c.JSON(fiber.Map{
+        "transfers": transfers,
+    })
+}
+
+// AcceptTransfer moves the ticket to the recipient and reissues its code, so
+// any QR code the sender kept is rejected at the gate.
+func AcceptTransfer(c *fiber.Ctx) error {
+    transferID := c.Params("id")
+    userID := c.Locals("userID").(string)
+
+    var transfer models.TicketTransfer
+    var ticket models.Ticket
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("transfer_id = ? AND to_user_id = ?", transferID, userID).
+            First(&transfer).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Transfer not found")
+        }
+
+        if transfer.Status != "pending" {
+            return fiber.NewError(fiber.StatusConflict, "Transfer is already "+transfer.Status)
+        }
+
+        if !transfer.ExpiresAt.After(time.Now()) {
+            return fiber.NewError(fiber.StatusGone, "Transfer has expired")
+        }
+
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("ticket_id = ?", transfer.TicketID).
+            First(&ticket).Error; err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "Ticket not found")
+        }
+
+        // The ticket may have been used or the organizer may have disabled
+        // transfers since the offer was made
+        if ticket.OwnerID != transfer.FromUserID {
+            return fiber.NewError(fiber.StatusConflict, "Ticket no longer belongs to the sender")
+        }
+        if reason, _ := transferBlocked(tx, &ticket); reason != "" {
+            return fiber.NewError(fiber.StatusBadRequest, reason)
+        }
+
+        if err := tx.Model(&models.Ticket{}).Where("ticket_id = ?", ticket.TicketID).Update("owner_id", userID).Error; err != nil {
+            return err
+        }
+        ticket.OwnerID = userID
+
+        if err := reissueTicketCode(tx, &ticket); err != nil {
+            return err
+        }
+
+        if err := clearAttendee(tx, ticket.TicketID); err != nil {
+            return err
+        }
+
+        now := time.Now()
+        transfer.Status = "accepted"
+        transfer.RespondedAt = &now
+        return tx.Model(&transfer).Updates(map[string]interface{}{
+            "status":       transfer.Status,
+            "responded_at": now,
+        }).Error
+    })
+
+    if err != nil {
+        return transferError(c, err)
+    }
+
+    notifyUser(transfer.FromUserID, "Your ticket transfer was accepted",
+        "The recipient accepted your ticket. Your copy of the QR code is no longer valid.")
+
+    return c.JSON(fiber.Map{
+        "message":  "Transfer accepted, the ticket is now yours",
+        "transfer": transfer,
+        "ticket":   ticket,
+    })
+}
+
+func DeclineTransfer(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    transfer, err := closeTransfer(c.Params("id"), "to_user_id", userID, "declined")
+    if err != nil {
+        return transferError(c, err)
+    }
+
+    notifyUser(transfer.FromUserID, "Your ticket transfer was declined",
+        "The recipient declined your ticket. It is still yours and your QR code keeps working.")
+
+    return c.JSON(fiber.Map{
+        "message":  "Transfer declined",
+        "transfer": transfer,
+    })
+}
+
+func CancelTransfer(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    transfer, err := closeTransfer(c.Params("id"), "from_user_id", userID, "cancelled")
+    if err != nil {
+        return transferError(c, err)
+    }
+
+    return c.JSON(fiber.Map{
+        "message":  "Transfer cancelled",
+        "transfer": transfer,
+    })
+}
+
+// closeTransfer ends a pending transfer without moving the ticket. party is
+// the column that must match the user: the recipient declines, the sender
+// cancels.
+func closeTransfer(transferID, party, userID, status string) (*models.TicketTransfer, error) {
+    var transfer models.TicketTransfer
+    if err := config.DB.Where("transfer_id = ? AND "+party+" = ?", transferID, userID).First(&transfer).Error; err != nil {
+        return nil, fiber.NewError(fiber.StatusNotFound, "Transfer not found")
+    }
+
+    now := time.Now()
+    result := config.DB.Model(&models.TicketTransfer{}).
+        Where("transfer_id = ? AND status = ?", transfer.TransferID, "pending").
+        Updates(map[string]interface{}{
+            "status":       status,
+            "responded_at": now,
+        })
+    if result.Error != nil {
+        return nil, result.Error
+    }
+    if result.RowsAffected == 0 {
+        return nil, fiber.NewError(fiber.StatusConflict, "Transfer is already "+transfer.Status)
+    }
+
+    transfer.Status = status
+    transfer.RespondedAt = &now
+    return &transfer, nil
+}
+
+func transferError(c *fiber.Ctx, err error) error {
+    status := fiber.StatusInternalServerError
+    message := "Failed to update transfer"
+    var fiberErr *fiber.Error
+    if errors.As(err, &fiberErr) {
+        status = fiberErr.Code
+        message = fiberErr.Message
+    }
+    return c.Status(status).JSON(fiber.Map{
+        "error": message,
+    })
+}
@@ -1,11 +1,31 @@
 package controllers
 
 import (
+    "log"
+
     "github.com/gofiber/fiber/v2"
     "ticketing-backend/config"
     "ticketing-backend/models"
+    "ticketing-backend/utils"
 )
 
+// notifyUser emails a user about something that happened to their tickets or
+// events. Failures are only logged.
+func notifyUser(userID, subject, body string) {
+    var user models.User
+    if err := config.DB.Where("user_id = ?", userID).First(&user).Error; err != nil {
+        return
+    }
+
+    if err := utils.Mail.Send(utils.Message{
+        To:      user.Email,
+        Subject: subject,
+        Body:    "Hi " + user.Name + ",\n\n" + body,
+    }); err != nil {
+        log.Println("Failed to send email to", user.Email+":", err)
+    }
+}
+
 func GetProfile(c *fiber.Ctx) error {
     userID := c.Locals("userID").(string)
 
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// issueUserToken creates a single-use token for purpose and invalidates any
+// earlier unused ones, so only the newest emailed link works.
+func issueUserToken(tx *gorm.DB, userID, purpose string, ttl time.Duration) (string, error) {
+    now := time.Now()
+    if err := tx.Model(&models.UserToken{}).
+        Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+        Update("used_at", now).Error; err != nil {
+        return "", err
+    }
+
+    token, err := utils.GenerateOpaqueToken()
+    if err != nil {
+        return "", err
+    }
+
+    if err := tx.Create(&models.UserToken{
+        UserID:    userID,
+        Purpose:   purpose,
+        TokenHash: utils.HashToken(token),
+        ExpiresAt: now.Add(ttl),
+    }).Error; err != nil {
+        return "", err
+    }
+
+    return token, nil
+}
+
+// consumeUserToken marks a valid token as used and returns it. The update is
+// conditional, so two requests racing on the same token cannot both win.
+func consumeUserToken(tx *gorm.DB, token, purpose string) (*models.UserToken, error) {
+    hash := utils.HashToken(token)
+    now := time.Now()
+
+    result := tx.Model(&models.UserToken{}).
+        Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+        Update("used_at", now)
+    if result.Error != nil {
+        return nil, result.Error
+    }
+    if result.RowsAffected == 0 {
+        return nil, gorm.ErrRecordNotFound
+    }
+
+    var userToken models.UserToken
+    if err := tx.Where("token_hash = ?", hash).First(&userToken).Error; err != nil {
+        return nil, err
+    }
+    return &userToken, nil
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+    "errors"
+    "net/url"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+const (
+    emailVerificationTTL      = 48 * time.Hour
+    emailVerificationCooldown = time.Minute
+)
+
+type VerifyEmailRequest struct {
+    Token string `json:"token"`
+}
+
+func sendVerificationEmail(user *models.User) error {
+    token, err := issueUserToken(config.DB, user.UserID, "email_verification", emailVerificationTTL)
+    if err != nil {
+        return err
+    }
+
+    link := config.AppURL() + "/verify-email?token=" + url.QueryEscape(token)
+    return utils.Mail.Send(utils.Message{
+        To:      user.Email,
+        Subject: "Verify your email address",
+        Body:    "Hi " + user.Name + ",\n\nPlease confirm your email address by opening the link below. It expires in 48 hours.\n\n" + link,
+    })
+}
+
+// MarkLegacyUsersVerified treats accounts created before email verification
+// existed as verified, so they are not locked out of checkout.
+func MarkLegacyUsersVerified() (int64, error) {
+    result := config.DB.Model(&models.User{}).
+        Where("email_verified_at IS NULL").
+        UpdateColumn("email_verified_at", gorm.Expr("created_at"))
+    return result.RowsAffected, result.Error
+}
+
+func VerifyEmail(c *fiber.Ctx) error {
+    var req VerifyEmailRequest
+    if err := c.BodyParser(&req); err != nil || req.Token == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Token is required",
+        })
+    }
+
+    err := config.DB.Transaction(func(tx *gorm.DB) error {
+        userToken, err := consumeUserToken(tx, req.Token, "email_verification")
+        if err != nil {
+            return err
+        }
+
+        return tx.Model(&models.User{}).
+            Where("user_id = ? AND email_verified_at IS NULL", userToken.UserID).
+            Update("email_verified_at", time.Now()).Error
+    })
+
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Verification token is invalid or has expired",
+        })
+    }
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to verify email",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Email verified successfully",
+    })
+}
+
+func ResendVerification(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var user models.User
+    if err := config.DB.Where("user_id = ?", userID).First(&user).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+            "error": "User not found",
+        })
+    }
+
+    if user.EmailVerifiedAt != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Email is already verified",
+        })
+    }
+
+    var recent int64
+    config.DB.Model(&models.UserToken{}).
+        Where("user_id = ? AND purpose = ? AND created_at > ?", userID, "email_verification", time.Now().Add(-emailVerificationCooldown)).
+        Count(&recent)
+    if recent > 0 {
+        return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+            "error": "Please wait a minute before requesting another email",
+        })
+    }
+
+    if err := sendVerificationEmail(&user); err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to send verification email",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "message": "Verification email sent",
+    })
+}
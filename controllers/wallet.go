@@ -0,0 +1,99 @@
+package controllers
+
+import (
+    "log"
+
+    "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+    "ticketing-backend/utils"
+)
+
+// walletPass loads an active ticket of the user with the details printed on
+// its e-ticket.
+func walletPass(c *fiber.Ctx) (*utils.WalletPass, error) {
+    var ticket models.Ticket
+    if err := config.DB.Where("ticket_id = ? AND owner_id = ?", c.Params("id"), c.Locals("userID").(string)).First(&ticket).Error; err != nil {
+        return nil, fiber.NewError(fiber.StatusNotFound, "Ticket not found")
+    }
+
+    if ticket.Status != "active" {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "Only active tickets can be added to a wallet")
+    }
+
+    pages, err := ticketPDFs([]models.Ticket{ticket})
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to load ticket details")
+    }
+    page := pages[0]
+
+    return &utils.WalletPass{
+        TicketID:     ticket.TicketID,
+        Code:         ticket.Code,
+        EventID:      ticket.EventID,
+        EventName:    page.EventName,
+        DateStart:    page.DateStart,
+        DateEnd:      page.DateEnd,
+        Location:     page.Location,
+        Category:     page.Category,
+        AttendeeName: page.AttendeeName,
+    }, nil
+}
+
+func GetTicketApplePass(c *fiber.Ctx) error {
+    if utils.AppleWallet == nil {
+        return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+            "error": "Apple Wallet passes are not available",
+        })
+    }
+
+    pass, err := walletPass(c)
+    if err != nil {
+        fiberErr := err.(*fiber.Error)
+        return c.Status(fiberErr.Code).JSON(fiber.Map{
+            "error": fiberErr.Message,
+        })
+    }
+
+    pkpass, err := utils.AppleWallet.BuildPass(*pass)
+    if err != nil {
+        log.Println("Failed to sign pass for ticket", pass.TicketID+":", err)
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to generate pass",
+        })
+    }
+
+    c.Set(fiber.HeaderContentType, "application/vnd.apple.pkpass")
+    c.Set(fiber.HeaderContentDisposition, `attachment; filename="ticket-`+pass.TicketID+`.pkpass"`)
+    c.Set(fiber.HeaderCacheControl, "private, no-store")
+    return c.Send(pkpass)
+}
+
+func GetTicketGoogleWalletLink(c *fiber.Ctx) error {
+    if utils.GoogleWallet == nil {
+        return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+            "error": "Google Wallet passes are not available",
+        })
+    }
+
+    pass, err := walletPass(c)
+    if err != nil {
+        fiberErr := err.(*fiber.Error)
+        return c.Status(fiberErr.Code).JSON(fiber.Map{
+            "error": fiberErr.Message,
+        })
+    }
+
+    token, err := utils.GoogleWallet.SaveJWT(*pass)
+    if err != nil {
+        log.Println("Failed to sign Google Wallet pass for ticket", pass.TicketID+":", err)
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to generate pass",
+        })
+    }
+
+    return c.JSON(fiber.Map{
+        "jwt":      token,
+        "save_url": utils.GoogleWalletSaveURL + token,
+    })
+}
@@ -5,13 +5,21 @@ import (
     "github.com/gofiber/fiber/v2/middleware/cors"
     "github.com/gofiber/fiber/v2/middleware/logger"
     "log"
+    "time"
     "ticketing-backend/config"
     "ticketing-backend/controllers"
     "ticketing-backend/middleware"
     "ticketing-backend/models"
+    "ticketing-backend/utils"
 )
 
 func main() {
+    // Gate devices trust every code signed with this key, so there is no
+    // default. Loaded first because legacy codes are reissued on setup.
+    if err := utils.LoadTicketSigningKey(); err != nil {
+        log.Fatal("Ticket signing key not configured:", err)
+    }
+
     // Connect to database
     config.ConnectDB()
 
@@ -24,12 +32,35 @@ func main() {
     app.Use(logger.New())
     app.Use(cors.New(cors.Config{
         AllowOrigins: "*",
-        AllowHeaders: "Origin, Content-Type, Accept, Authorization",
+        AllowHeaders: "Origin, Content-Type, Accept, Authorization, Idempotency-Key",
     }))
 
+    // Payment provider and mailer
+    provider, err := utils.NewPaymentProvider()
+    if err != nil {
+        log.Fatal("Payment provider not configured:", err)
+    }
+    utils.Payment = provider
+    utils.Mail = utils.NewMailer()
+
+    // Wallet passes stay off until their signing credentials are configured
+    if signer, err := utils.NewAppleWalletSigner(); err != nil {
+        log.Println("Apple Wallet passes disabled:", err)
+    } else {
+        utils.AppleWallet = signer
+    }
+    if signer, err := utils.NewGoogleWalletSigner([]string{config.AppURL()}); err != nil {
+        log.Println("Google Wallet passes disabled:", err)
+    } else {
+        utils.GoogleWallet = signer
+    }
+
     // Setup routes
     setupRoutes(app)
 
+    // Release cart holds and unpaid orders that ran out
+    go startSweeper()
+
     log.Println("Server running on port 3000")
     log.Println("Database setup completed")
     app.Listen(":3000")
@@ -39,6 +70,31 @@ func setupDatabase() {
     // Disable foreign key checks
     config.DB.Exec("SET FOREIGN_KEY_CHECKS=0")
 
+    // Users from before email verification have no email_verified_at column yet
+    legacyUsers := config.DB.Migrator().HasTable(&models.User{}) && !config.DB.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
+    // Events from before the review workflow have no submitted_at column yet
+    legacyEvents := config.DB.Migrator().HasTable(&models.Event{}) && !config.DB.Migrator().HasColumn(&models.Event{}, "SubmittedAt")
+
+    // Carts from before the unique item index can hold a category twice
+    if config.DB.Migrator().HasTable(&models.Cart{}) && !config.DB.Migrator().HasColumn(&models.Cart{}, "ItemKey") {
+        if merged, err := controllers.MigrateLegacyCarts(); err != nil {
+            log.Fatal("Failed to migrate legacy carts:", err)
+        } else if merged > 0 {
+            log.Printf("Merged duplicate cart rows for %d items", merged)
+        }
+    }
+
+    // Tickets minted before codes were signed get a signed code. The
+    // code_version column added below marks the table as done.
+    if config.DB.Migrator().HasTable(&models.Ticket{}) && !config.DB.Migrator().HasColumn(&models.Ticket{}, "CodeVersion") {
+        if reissued, err := controllers.ReissueLegacyTicketCodes(); err != nil {
+            log.Fatal("Failed to reissue legacy ticket codes:", err)
+        } else if reissued > 0 {
+            log.Printf("Reissued %d legacy ticket codes", reissued)
+        }
+    }
+
     // Auto migrate tanpa foreign key constraints
     err := config.DB.Set("gorm:table_options", "ENGINE=InnoDB CHARSET=utf8mb4").AutoMigrate(
         &models.User{},
@@ -48,6 +104,24 @@ func setupDatabase() {
         &models.TransactionHistory{},
         &models.Ticket{},
         &models.Cart{},
+        &models.Order{},
+        &models.OrderItem{},
+        &models.Payment{},
+        &models.PaymentNotification{},
+        &models.IdempotencyKey{},
+        &models.Session{},
+        &models.UserToken{},
+        &models.EventStaff{},
+        &models.CheckIn{},
+        &models.TicketTransfer{},
+        &models.ResaleListing{},
+        &models.SellerCredit{},
+        &models.Attendee{},
+        &models.RegistrationQuestion{},
+        &models.RegistrationAnswer{},
+        &models.Refund{},
+        &models.EventReschedule{},
+        &models.EventRevision{},
     )
     
     if err != nil {
@@ -56,49 +130,190 @@ func setupDatabase() {
 
     // Enable foreign key checks kembali
     config.DB.Exec("SET FOREIGN_KEY_CHECKS=1")
-    
+
+    if legacyUsers {
+        if migrated, err := controllers.MarkLegacyUsersVerified(); err != nil {
+            log.Println("Failed to mark legacy users as verified:", err)
+        } else if migrated > 0 {
+            log.Printf("Marked %d legacy users as verified", migrated)
+        }
+    }
+
+    if legacyEvents {
+        if migrated, err := controllers.MigrateLegacyEventStatuses(); err != nil {
+            log.Println("Failed to migrate legacy event statuses:", err)
+        } else if migrated > 0 {
+            log.Printf("Migrated %d legacy events to the review workflow", migrated)
+        }
+    }
+
     log.Println("Database tables created successfully")
 }
 
+func startSweeper() {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        released, err := controllers.ReleaseExpiredCartHolds()
+        if err != nil {
+            log.Println("Cart hold sweeper failed:", err)
+        } else if released > 0 {
+            log.Printf("Released %d expired cart holds", released)
+        }
+
+        expired, err := controllers.ExpireUnpaidOrders()
+        if err != nil {
+            log.Println("Order expiry sweeper failed:", err)
+        } else if expired > 0 {
+            log.Printf("Expired %d unpaid orders", expired)
+        }
+
+        refunded, err := controllers.ProcessPendingRefunds()
+        if err != nil {
+            log.Println("Refund sweeper failed:", err)
+        } else if refunded > 0 {
+            log.Printf("Processed %d pending refunds", refunded)
+        }
+
+        completed, err := controllers.CompleteEndedEvents()
+        if err != nil {
+            log.Println("Event completion sweeper failed:", err)
+        } else if completed > 0 {
+            log.Printf("Completed %d ended events", completed)
+        }
+
+        if _, err := middleware.PurgeExpiredIdempotencyKeys(); err != nil {
+            log.Println("Idempotency key cleanup failed:", err)
+        }
+    }
+}
+
 func setupRoutes(app *fiber.App) {
     // Auth routes
     auth := app.Group("/api/auth")
     auth.Post("/register", controllers.Register)
     auth.Post("/login", controllers.Login)
+    auth.Post("/refresh", controllers.Refresh)
+    auth.Post("/logout", middleware.AuthMiddleware, controllers.Logout)
+    auth.Post("/forgot-password", controllers.ForgotPassword)
+    auth.Post("/reset-password", controllers.ResetPassword)
+    auth.Post("/verify-email", controllers.VerifyEmail)
+    auth.Post("/resend-verification", middleware.AuthMiddleware, controllers.ResendVerification)
 
     // User routes
     user := app.Group("/api/users")
     user.Use(middleware.AuthMiddleware)
     user.Get("/profile", controllers.GetProfile)
     user.Put("/profile", controllers.UpdateProfile)
+    user.Put("/password", controllers.ChangePassword)
+    user.Get("/staff-events", controllers.GetStaffEvents)
     user.Get("", middleware.AdminMiddleware, controllers.GetUsers)
     user.Post("/:id/verify", middleware.AdminMiddleware, controllers.VerifyUser)
 
     // Event routes
     event := app.Group("/api/events")
     event.Get("", controllers.GetEvents)
+    event.Get("/mine", middleware.AuthMiddleware, middleware.EOMiddleware, controllers.GetMyEvents)
+    event.Get("/review", middleware.AuthMiddleware, middleware.AdminMiddleware, controllers.GetEventsForReview)
+    event.Get("/revisions", middleware.AuthMiddleware, middleware.AdminMiddleware, controllers.GetRevisionsForReview)
     event.Get("/:id", controllers.GetEvent)
+    event.Get("/:id/categories", controllers.GetTicketCategories)
+    event.Get("/:id/resale", controllers.GetEventResaleListings)
+    event.Get("/:id/questions", controllers.GetRegistrationQuestions)
+    event.Get("/:id/reschedules", controllers.GetEventReschedules)
+    event.Get("/:id/checkins/stream", middleware.StreamAuthMiddleware, controllers.StreamCheckIns)
     
     eventAuth := event.Group("")
     eventAuth.Use(middleware.AuthMiddleware)
     eventAuth.Post("", middleware.EOMiddleware, controllers.CreateEvent)
     eventAuth.Put("/:id", middleware.EOMiddleware, controllers.UpdateEvent)
     eventAuth.Delete("/:id", middleware.EOMiddleware, controllers.DeleteEvent)
+    eventAuth.Post("/:id/submit", middleware.EOMiddleware, controllers.SubmitEvent)
     eventAuth.Patch("/:id/verify", middleware.AdminMiddleware, controllers.VerifyEvent)
+    eventAuth.Patch("/:id/reject", middleware.AdminMiddleware, controllers.RejectEvent)
+    eventAuth.Post("/:id/publish", middleware.EOMiddleware, controllers.PublishEvent)
+    eventAuth.Get("/:id/revisions", middleware.EOMiddleware, controllers.GetEventRevisions)
+    eventAuth.Patch("/revisions/:revisionId/approve", middleware.AdminMiddleware, controllers.ApproveEventRevision)
+    eventAuth.Patch("/revisions/:revisionId/reject", middleware.AdminMiddleware, controllers.RejectEventRevision)
+    eventAuth.Post("/:id/cancel", middleware.EOMiddleware, controllers.CancelEvent)
+    eventAuth.Post("/:id/categories", middleware.EOMiddleware, controllers.CreateTicketCategory)
+    eventAuth.Put("/:id/categories/:categoryId", middleware.EOMiddleware, controllers.UpdateTicketCategory)
+    eventAuth.Delete("/:id/categories/:categoryId", middleware.EOMiddleware, controllers.DeleteTicketCategory)
+    eventAuth.Get("/:id/transactions", middleware.EOMiddleware, controllers.GetEventTransactions)
+    eventAuth.Post("/:id/questions", middleware.EOMiddleware, controllers.CreateRegistrationQuestion)
+    eventAuth.Put("/:id/questions/:questionId", middleware.EOMiddleware, controllers.UpdateRegistrationQuestion)
+    eventAuth.Delete("/:id/questions/:questionId", middleware.EOMiddleware, controllers.DeleteRegistrationQuestion)
+    eventAuth.Get("/:id/registrations", middleware.EOMiddleware, controllers.ExportRegistrations)
+    eventAuth.Get("/:id/staff", middleware.EOMiddleware, controllers.GetEventStaff)
+    eventAuth.Post("/:id/staff", middleware.EOMiddleware, controllers.AssignEventStaff)
+    eventAuth.Delete("/:id/staff/:userId", middleware.EOMiddleware, controllers.RemoveEventStaff)
+    eventAuth.Post("/:id/checkin", controllers.CheckInByCode)
+    eventAuth.Get("/:id/checkins", controllers.GetCheckIns)
+    eventAuth.Get("/:id/checkins/stats", controllers.GetCheckInStats)
+    eventAuth.Post("/:id/checkins/stream-token", controllers.CreateStreamToken)
+    eventAuth.Get("/:id/manifest", controllers.GetEventManifest)
+    eventAuth.Post("/:id/scans/sync", controllers.SyncOfflineScans)
 
     // Ticket routes
+    app.Get("/api/tickets/public-key", controllers.GetTicketPublicKey)
     ticket := app.Group("/api/tickets")
     ticket.Use(middleware.AuthMiddleware)
-    ticket.Post("", controllers.CreateTicket)
+    ticket.Post("", middleware.VerifiedEmailMiddleware, middleware.IdempotencyMiddleware, controllers.CreateTicket)
     ticket.Get("", controllers.GetTickets)
+    ticket.Post("/verify", controllers.VerifyTicketCode)
     ticket.Get("/:id", controllers.GetTicket)
+    ticket.Get("/:id/qr", controllers.GetTicketQR)
+    ticket.Get("/:id/pdf", controllers.GetTicketPDF)
+    ticket.Get("/:id/wallet/apple", controllers.GetTicketApplePass)
+    ticket.Get("/:id/wallet/google", controllers.GetTicketGoogleWalletLink)
     ticket.Patch("/:id/checkin", controllers.CheckInTicket)
+    ticket.Put("/:id/attendee", controllers.UpdateTicketAttendee)
+    ticket.Post("/:id/transfer", controllers.TransferTicket)
+    ticket.Post("/:id/resale", controllers.ListTicketForResale)
+    ticket.Delete("/:id/resale", controllers.CancelResaleListing)
+    ticket.Post("/:id/reschedule-refund", controllers.RequestRescheduleRefund)
+
+    // Transfer routes
+    transfer := app.Group("/api/transfers")
+    transfer.Use(middleware.AuthMiddleware)
+    transfer.Get("", controllers.GetTransfers)
+    transfer.Post("/:id/accept", controllers.AcceptTransfer)
+    transfer.Post("/:id/decline", controllers.DeclineTransfer)
+    transfer.Post("/:id/cancel", controllers.CancelTransfer)
+
+    // Resale routes
+    resale := app.Group("/api/resale")
+    resale.Use(middleware.AuthMiddleware)
+    resale.Get("/listings", controllers.GetMyResaleListings)
+    resale.Get("/credits", controllers.GetSellerCredits)
 
     // Cart routes
     cart := app.Group("/api/cart")
     cart.Use(middleware.AuthMiddleware)
+    cart.Get("", controllers.GetCart)
     cart.Post("", controllers.AddToCart)
     cart.Patch("", controllers.UpdateCart)
     cart.Delete("", controllers.DeleteFromCart)
-    cart.Post("/checkout", controllers.Checkout)
+    cart.Post("/checkout", middleware.VerifiedEmailMiddleware, middleware.IdempotencyMiddleware, controllers.Checkout)
+
+    // Order routes
+    order := app.Group("/api/orders")
+    order.Use(middleware.AuthMiddleware)
+    order.Get("", controllers.GetOrders)
+    order.Get("/:id", controllers.GetOrder)
+    order.Get("/:id/payment", controllers.GetOrderPayment)
+    order.Get("/:id/pdf", controllers.GetOrderPDF)
+
+    // Payment routes
+    payment := app.Group("/api/payments")
+    payment.Post("/callback", controllers.PaymentCallback)
+    if utils.Payment.Name() == "mock" {
+        payment.Post("/mock/:reference", middleware.AuthMiddleware, controllers.MockPayment)
+    }
+
+    // Transaction routes
+    transaction := app.Group("/api/transactions")
+    transaction.Use(middleware.AuthMiddleware)
+    transaction.Get("", controllers.GetTransactionHistory)
 }
\ No newline at end of file
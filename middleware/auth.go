@@ -3,6 +3,8 @@ package middleware
 import (
     "strings"
     "github.com/gofiber/fiber/v2"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
     "ticketing-backend/utils"
 )
 
@@ -22,8 +24,45 @@ func AuthMiddleware(c *fiber.Ctx) error {
         })
     }
 
+    // Sessions end on logout, password changes and refresh token reuse
+    var session models.Session
+    if claims.SessionID == "" || config.DB.Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", claims.SessionID, claims.UserID).First(&session).Error != nil {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Session has been revoked",
+        })
+    }
+
     c.Locals("userID", claims.UserID)
     c.Locals("role", claims.Role)
+    c.Locals("sessionID", claims.SessionID)
+    return c.Next()
+}
+
+// StreamAuthMiddleware authenticates check-in streams. A browser's
+// EventSource cannot send an Authorization header, so a stream token for the
+// event in the token query parameter is accepted instead.
+func StreamAuthMiddleware(c *fiber.Ctx) error {
+    if c.Get("Authorization") != "" {
+        return AuthMiddleware(c)
+    }
+
+    claims, err := utils.ValidateStreamToken(c.Query("token"))
+    if err != nil || claims.EventID != c.Params("id") {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Invalid stream token",
+        })
+    }
+
+    var session models.Session
+    if config.DB.Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", claims.SessionID, claims.UserID).First(&session).Error != nil {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "Session has been revoked",
+        })
+    }
+
+    c.Locals("userID", claims.UserID)
+    c.Locals("role", claims.Role)
+    c.Locals("sessionID", claims.SessionID)
     return c.Next()
 }
 
@@ -45,4 +84,22 @@ func EOMiddleware(c *fiber.Ctx) error {
         })
     }
     return c.Next()
-}
\ No newline at end of file
+}
+
+func VerifiedEmailMiddleware(c *fiber.Ctx) error {
+    userID := c.Locals("userID").(string)
+
+    var user models.User
+    if err := config.DB.Select("user_id", "email_verified_at").Where("user_id = ?", userID).First(&user).Error; err != nil {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+            "error": "User not found",
+        })
+    }
+
+    if user.EmailVerifiedAt == nil {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "Please verify your email address before buying tickets",
+        })
+    }
+    return c.Next()
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm/clause"
+    "ticketing-backend/config"
+    "ticketing-backend/models"
+)
+
+// IdempotencyKeyTTL is how long a stored response can be replayed.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyMiddleware stores the first response for each user and
+// Idempotency-Key header and replays it when the same request is retried.
+// Must run after AuthMiddleware.
+func IdempotencyMiddleware(c *fiber.Ctx) error {
+    key := c.Get("Idempotency-Key")
+    if key == "" {
+        return c.Next()
+    }
+
+    if len(key) > 191 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Idempotency-Key is too long",
+        })
+    }
+
+    userID := c.Locals("userID").(string)
+
+    sum := sha256.Sum256([]byte(c.Method() + " " + c.Path() + "\n" + string(c.Body())))
+    requestHash := hex.EncodeToString(sum[:])
+
+    record := models.IdempotencyKey{
+        UserID:      userID,
+        Key:         key,
+        RequestHash: requestHash,
+    }
+    result := config.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+    if result.Error != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": "Failed to store idempotency key",
+        })
+    }
+
+    if result.RowsAffected == 0 {
+        var existing models.IdempotencyKey
+        if err := config.DB.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error; err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "error": "Failed to load idempotency key",
+            })
+        }
+
+        if existing.RequestHash != requestHash {
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+                "error": "Idempotency-Key was already used with a different request",
+            })
+        }
+
+        if existing.StatusCode == 0 {
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+                "error": "A request with this Idempotency-Key is still being processed",
+            })
+        }
+
+        c.Set("Idempotent-Replayed", "true")
+        c.Set(fiber.HeaderContentType, existing.ContentType)
+        return c.Status(existing.StatusCode).SendString(existing.ResponseBody)
+    }
+
+    if err := c.Next(); err != nil {
+        config.DB.Delete(&record)
+        return err
+    }
+
+    // Server errors are not stored so the client can retry with the same key
+    status := c.Response().StatusCode()
+    if status >= fiber.StatusInternalServerError {
+        config.DB.Delete(&record)
+        return nil
+    }
+
+    config.DB.Model(&record).Updates(map[string]interface{}{
+        "status_code":   status,
+        "content_type":  string(c.Response().Header.ContentType()),
+        "response_body": string(c.Response().Body()),
+    })
+
+    return nil
+}
+
+// PurgeExpiredIdempotencyKeys removes stored responses older than the TTL.
+func PurgeExpiredIdempotencyKeys() (int64, error) {
+    result := config.DB.Where("created_at <= ?", time.Now().Add(-IdempotencyKeyTTL)).Delete(&models.IdempotencyKey{})
+    return result.RowsAffected, result.Error
+}
@@ -8,52 +8,71 @@ import (
 )
 
 type User struct {
-    UserID                    string    `gorm:"primaryKey;size:191" json:"user_id"`
-    Username                  string    `gorm:"unique;not null;size:100" json:"username"`
-    Name                      string    `gorm:"not null" json:"name"`
-    Email                     string    `gorm:"unique;not null;size:150" json:"email"`
-    Password                  string    `gorm:"not null" json:"-"`
-    Role                      string    `gorm:"not null;size:50" json:"role"`
-    ProfilePic                string    `gorm:"type:text" json:"profile_pic"`
-    Organization              *string   `gorm:"size:200" json:"organization,omitempty"`
-    OrganizationType          *string   `gorm:"size:100" json:"organization_type,omitempty"`
-    OrganizationDescription   *string   `gorm:"type:text" json:"organization_description,omitempty"`
-    KTP                       *string   `gorm:"size:50" json:"ktp,omitempty"`
-    RegisterStatus            string    `gorm:"default:pending;size:50" json:"register_status"`
-    RefreshToken              *string   `gorm:"type:text" json:"-"`
-    AccessToken               *string   `gorm:"type:text" json:"-"`
-    CreatedAt                 time.Time `json:"created_at"`
-    UpdatedAt                 time.Time `json:"updated_at"`
+    UserID                    string     `gorm:"primaryKey;size:191" json:"user_id"`
+    Username                  string     `gorm:"unique;not null;size:100" json:"username"`
+    Name                      string     `gorm:"not null" json:"name"`
+    Email                     string     `gorm:"unique;not null;size:150" json:"email"`
+    Password                  string     `gorm:"not null" json:"-"`
+    Role                      string     `gorm:"not null;size:50" json:"role"`
+    ProfilePic                string     `gorm:"type:text" json:"profile_pic"`
+    Organization              *string    `gorm:"size:200" json:"organization,omitempty"`
+    OrganizationType          *string    `gorm:"size:100" json:"organization_type,omitempty"`
+    OrganizationDescription   *string    `gorm:"type:text" json:"organization_description,omitempty"`
+    KTP                       *string    `gorm:"size:50" json:"ktp,omitempty"`
+    RegisterStatus            string     `gorm:"default:pending;size:50" json:"register_status"`
+    EmailVerifiedAt           *time.Time `json:"email_verified_at"`
+    RefreshToken              *string    `gorm:"type:text" json:"-"`
+    AccessToken               *string    `gorm:"type:text" json:"-"`
+    CreatedAt                 time.Time  `json:"created_at"`
+    UpdatedAt                 time.Time  `json:"updated_at"`
 }
 
 type Event struct {
-    EventID          string    `gorm:"primaryKey;size:191" json:"event_id"`
-    Name             string    `gorm:"not null;size:200" json:"name"`
-    OwnerID          string    `gorm:"not null;size:191" json:"owner_id"`
-    Status           string    `gorm:"default:pending;size:50" json:"status"`
-    ApprovalComment  *string   `gorm:"type:text" json:"approval_comment"`
-    DateStart        time.Time `gorm:"not null" json:"date_start"`
-    DateEnd          time.Time `gorm:"not null" json:"date_end"`
-    Location         string    `gorm:"not null" json:"location"`
-    Description      string    `gorm:"type:text" json:"description"`
-    Image            *string   `gorm:"type:text" json:"image"`
-    Flyer            *string   `gorm:"type:text" json:"flyer"`
-    Category         string    `gorm:"size:100" json:"category"`
-    CreatedAt        time.Time `json:"created_at"`
-    UpdatedAt        time.Time `json:"updated_at"`
+    EventID            string     `gorm:"primaryKey;size:191" json:"event_id"`
+    Name               string     `gorm:"not null;size:200;index:idx_events_search,class:FULLTEXT" json:"name"`
+    OwnerID            string     `gorm:"not null;size:191" json:"owner_id"`
+    Status             string     `gorm:"default:draft;size:50;index:idx_events_status_date,priority:1" json:"status"`
+    ApprovalComment    *string    `gorm:"type:text" json:"approval_comment"`
+    DateStart          time.Time  `gorm:"not null;index:idx_events_status_date,priority:2" json:"date_start"`
+    DateEnd            time.Time  `gorm:"not null" json:"date_end"`
+    Location           string     `gorm:"not null" json:"location"`
+    Description        string     `gorm:"type:text;index:idx_events_search,class:FULLTEXT" json:"description"`
+    Image              *string    `gorm:"type:text" json:"image"`
+    Flyer              *string    `gorm:"type:text" json:"flyer"`
+    Category           string     `gorm:"size:100;index" json:"category"`
+    TransferDisabled   bool       `gorm:"default:false" json:"transfer_disabled"`
+    ResaleEnabled      bool       `gorm:"default:false" json:"resale_enabled"`
+    ResaleMaxMarkup    float64    `gorm:"default:0" json:"resale_max_markup"`
+    SubmittedAt        *time.Time `json:"submitted_at"`
+    ReviewedAt         *time.Time `json:"reviewed_at"`
+    PublishedAt        *time.Time `json:"published_at"`
+    CancelledAt        *time.Time `json:"cancelled_at"`
+    CancellationReason *string    `gorm:"type:text" json:"cancellation_reason"`
+    CreatedAt          time.Time  `json:"created_at"`
+    UpdatedAt          time.Time  `json:"updated_at"`
 }
 
 type TicketCategory struct {
-    TicketCategoryID string    `gorm:"primaryKey;size:191" json:"ticket_category_id"`
-    EventID          string    `gorm:"not null;size:191" json:"event_id"`
-    Price            float64   `gorm:"not null" json:"price"`
-    Quota            int       `gorm:"not null" json:"quota"`
-    Sold             int       `gorm:"default:0" json:"sold"`
-    Description      string    `gorm:"type:text" json:"description"`
-    DateStart        time.Time `gorm:"not null" json:"date_start"`
-    DateEnd          time.Time `gorm:"not null" json:"date_end"`
-    CreatedAt        time.Time `json:"created_at"`
-    UpdatedAt        time.Time `json:"updated_at"`
+    TicketCategoryID string     `gorm:"primaryKey;size:191" json:"ticket_category_id"`
+    EventID          string     `gorm:"not null;size:191;index:idx_ticket_categories_event_price,priority:1" json:"event_id"`
+    Name             string     `gorm:"size:100" json:"name"`
+    Price            float64    `gorm:"not null;index:idx_ticket_categories_event_price,priority:2" json:"price"`
+    Quota            int        `gorm:"not null" json:"quota"`
+    Sold             int        `gorm:"default:0" json:"sold"`
+    Held             int        `gorm:"default:0" json:"held"`
+    Description      string     `gorm:"type:text" json:"description"`
+    DateStart        time.Time  `gorm:"not null" json:"date_start"`
+    DateEnd          time.Time  `gorm:"not null" json:"date_end"`
+    Status           string     `gorm:"default:active;size:50" json:"status"`
+    AdmissionPolicy  string     `gorm:"default:single;size:20" json:"admission_policy"`
+    MaxEntries       int        `gorm:"default:0" json:"max_entries"`
+    TransferDisabled bool       `gorm:"default:false" json:"transfer_disabled"`
+    RequireAttendee  bool       `gorm:"default:false" json:"require_attendee"`
+    RequireIDNumber  bool       `gorm:"default:false" json:"require_id_number"`
+    AttendeeFields   []string   `gorm:"serializer:json;type:text" json:"attendee_fields"`
+    AttendeeCutoff   *time.Time `json:"attendee_cutoff"`
+    CreatedAt        time.Time  `json:"created_at"`
+    UpdatedAt        time.Time  `json:"updated_at"`
 }
 
 type Report struct {
@@ -69,33 +88,309 @@ type Report struct {
 type TransactionHistory struct {
     TransactionID   string    `gorm:"primaryKey;size:191" json:"transaction_id"`
     OwnerID         string    `gorm:"not null;size:191" json:"owner_id"`
-    EventID         string    `gorm:"not null;size:191" json:"event_id"`
+    EventID         string    `gorm:"not null;size:191;index" json:"event_id"`
+    OrderID         string    `gorm:"size:191;index" json:"order_id"`
+    Quantity        int       `gorm:"default:0" json:"quantity"`
     TransactionTime time.Time `json:"transaction_time"`
     TotalAmount     float64   `gorm:"not null" json:"total_amount"`
     Status          string    `gorm:"default:completed;size:50" json:"status"`
     CreatedAt       time.Time `json:"created_at"`
 }
 
+// Ticket is one admission to an event. CodeVersion is the format of Code;
+// tickets from before signed codes were reissued when the column was added.
 type Ticket struct {
-    TicketID         string    `gorm:"primaryKey;size:191" json:"ticket_id"`
-    EventID          string    `gorm:"not null;size:191" json:"event_id"`
-    TicketCategoryID string    `gorm:"not null;size:191" json:"ticket_category_id"`
-    OwnerID          string    `gorm:"not null;size:191" json:"owner_id"`
-    Status           string    `gorm:"default:active;size:50" json:"status"`
-    Code             string    `gorm:"unique;not null;size:255" json:"code"`
-    CreatedAt        time.Time `json:"created_at"`
-    UpdatedAt        time.Time `json:"updated_at"`
+    TicketID         string     `gorm:"primaryKey;size:191" json:"ticket_id"`
+    EventID          string     `gorm:"not null;size:191" json:"event_id"`
+    TicketCategoryID string     `gorm:"not null;size:191" json:"ticket_category_id"`
+    OwnerID          string     `gorm:"not null;size:191" json:"owner_id"`
+    OrderID          string     `gorm:"size:191;index" json:"order_id"`
+    Status           string     `gorm:"default:active;size:50" json:"status"`
+    Code             string     `gorm:"unique;not null;size:255" json:"code"`
+    CodeVersion      int        `gorm:"default:1" json:"-"`
+    CheckedInBy      *string    `gorm:"size:191" json:"checked_in_by"`
+    CheckedInAt      *time.Time `json:"checked_in_at"`
+    CreatedAt        time.Time  `json:"created_at"`
+    UpdatedAt        time.Time  `json:"updated_at"`
 }
 
+// Cart holds tickets of a category or one resale listing for a user. ItemKey
+// repeats the listing ID, or is empty for category tickets, because MySQL
+// lets NULLs repeat in the unique index that keeps one row per item.
 type Cart struct {
     CartID           string    `gorm:"primaryKey;size:191" json:"cart_id"`
-    UserID           string    `gorm:"not null;size:191" json:"user_id"`
+    UserID           string    `gorm:"not null;size:191;uniqueIndex:idx_carts_user_item,priority:1" json:"user_id"`
+    TicketCategoryID string    `gorm:"not null;size:191;uniqueIndex:idx_carts_user_item,priority:2" json:"ticket_category_id"`
+    ResaleListingID  *string   `gorm:"size:191;index" json:"resale_listing_id"`
+    ItemKey          string    `gorm:"not null;default:'';size:191;uniqueIndex:idx_carts_user_item,priority:3" json:"-"`
+    Quantity         int       `gorm:"not null" json:"quantity"`
+    ExpiresAt        time.Time `gorm:"index" json:"expires_at"`
+    CreatedAt        time.Time `json:"created_at"`
+    UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type Order struct {
+    OrderID        string     `gorm:"primaryKey;size:191" json:"order_id"`
+    UserID         string     `gorm:"not null;size:191;index" json:"user_id"`
+    Status         string     `gorm:"default:pending_payment;size:50" json:"status"`
+    TotalAmount    float64    `gorm:"not null" json:"total_amount"`
+    RefundedAmount float64    `gorm:"default:0" json:"refunded_amount"`
+    ExpiresAt      *time.Time `gorm:"index" json:"expires_at"`
+    PaidAt         *time.Time `json:"paid_at"`
+    CreatedAt      time.Time  `json:"created_at"`
+    UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type OrderItem struct {
+    OrderItemID      string    `gorm:"primaryKey;size:191" json:"order_item_id"`
+    OrderID          string    `gorm:"not null;size:191;index" json:"order_id"`
+    EventID          string    `gorm:"not null;size:191" json:"event_id"`
     TicketCategoryID string    `gorm:"not null;size:191" json:"ticket_category_id"`
     Quantity         int       `gorm:"not null" json:"quantity"`
+    ResaleListingID  *string   `gorm:"size:191;index" json:"resale_listing_id,omitempty"`
+    UnitPrice        float64   `gorm:"not null" json:"unit_price"`
+    Subtotal         float64   `gorm:"not null" json:"subtotal"`
+    CreatedAt        time.Time `json:"created_at"`
+}
+
+type Payment struct {
+    PaymentID  string     `gorm:"primaryKey;size:191" json:"payment_id"`
+    OrderID    string     `gorm:"not null;size:191;index" json:"order_id"`
+    Provider   string     `gorm:"not null;size:50" json:"provider"`
+    Reference  string     `gorm:"unique;not null;size:191" json:"reference"`
+    Amount     float64    `gorm:"not null" json:"amount"`
+    Status     string     `gorm:"default:pending;size:50" json:"status"`
+    PaymentURL string     `gorm:"type:text" json:"payment_url"`
+    PaidAt     *time.Time `json:"paid_at"`
+    CreatedAt  time.Time  `json:"created_at"`
+    UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+type PaymentNotification struct {
+    NotificationID string    `gorm:"primaryKey;size:191" json:"notification_id"`
+    PaymentID      string    `gorm:"size:191;index" json:"payment_id"`
+    Reference      string    `gorm:"not null;size:191" json:"reference"`
+    Status         string    `gorm:"not null;size:50" json:"status"`
+    Payload        string    `gorm:"type:text" json:"payload"`
+    CreatedAt      time.Time `json:"created_at"`
+}
+
+type IdempotencyKey struct {
+    IdempotencyKeyID string    `gorm:"primaryKey;size:191" json:"idempotency_key_id"`
+    UserID           string    `gorm:"not null;size:191;uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+    Key              string    `gorm:"column:idempotency_key;not null;size:191;uniqueIndex:idx_idempotency_user_key" json:"key"`
+    RequestHash      string    `gorm:"not null;size:64" json:"request_hash"`
+    StatusCode       int       `gorm:"default:0" json:"status_code"`
+    ContentType      string    `gorm:"size:100" json:"content_type"`
+    ResponseBody     string    `gorm:"type:longtext" json:"response_body"`
+    CreatedAt        time.Time `gorm:"index" json:"created_at"`
+    UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Session is one login. Refreshing rotates it into a new session of the same
+// family; presenting a rotated refresh token again revokes the whole family.
+type Session struct {
+    SessionID        string     `gorm:"primaryKey;size:191" json:"session_id"`
+    UserID           string     `gorm:"not null;size:191;index" json:"user_id"`
+    FamilyID         string     `gorm:"not null;size:191;index" json:"family_id"`
+    RefreshTokenHash string     `gorm:"unique;not null;size:64" json:"-"`
+    UserAgent        string     `gorm:"size:255" json:"user_agent"`
+    ExpiresAt        time.Time  `gorm:"not null" json:"expires_at"`
+    RotatedAt        *time.Time `json:"rotated_at"`
+    RevokedAt        *time.Time `json:"revoked_at"`
+    CreatedAt        time.Time  `json:"created_at"`
+    UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// UserToken is a single-use token mailed to a user, such as a password reset
+// link. Only the hash of the token is stored.
+type UserToken struct {
+    TokenID   string     `gorm:"primaryKey;size:191" json:"token_id"`
+    UserID    string     `gorm:"not null;size:191;index" json:"user_id"`
+    Purpose   string     `gorm:"not null;size:50" json:"purpose"`
+    TokenHash string     `gorm:"unique;not null;size:64" json:"-"`
+    ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+    UsedAt    *time.Time `json:"used_at"`
+    CreatedAt time.Time  `json:"created_at"`
+}
+
+// EventStaff lets a user scan tickets at the gates of one event.
+type EventStaff struct {
+    EventStaffID string    `gorm:"primaryKey;size:191" json:"event_staff_id"`
+    EventID      string    `gorm:"not null;size:191;uniqueIndex:idx_event_staff" json:"event_id"`
+    UserID       string    `gorm:"not null;size:191;uniqueIndex:idx_event_staff;index" json:"user_id"`
+    AssignedBy   string    `gorm:"not null;size:191" json:"assigned_by"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+// CheckIn is one scan at a gate, whether the ticket was admitted or not.
+type CheckIn struct {
+    CheckInID        string    `gorm:"primaryKey;size:191" json:"check_in_id"`
+    EventID          string    `gorm:"not null;size:191;index" json:"event_id"`
+    TicketID         *string   `gorm:"size:191;index" json:"ticket_id"`
+    TicketCategoryID *string   `gorm:"size:191" json:"ticket_category_id"`
+    Code             string    `gorm:"size:255" json:"code"`
+    ScannedBy        string    `gorm:"not null;size:191" json:"scanned_by"`
+    Gate             string    `gorm:"size:100" json:"gate"`
+    Direction        string    `gorm:"default:in;size:10" json:"direction"`
+    Device           string    `gorm:"size:100;uniqueIndex:idx_check_in_device_scan" json:"device"`
+    DeviceScanID     *string   `gorm:"size:191;uniqueIndex:idx_check_in_device_scan" json:"device_scan_id"`
+    Source           string    `gorm:"default:online;size:20" json:"source"`
+    Outcome          string    `gorm:"not null;size:50;index" json:"outcome"`
+    Message          string    `gorm:"size:255" json:"message"`
+    SupersededBy     *string   `gorm:"size:191;index" json:"superseded_by"`
+    ScannedAt        time.Time `gorm:"not null;index" json:"scanned_at"`
+    CreatedAt        time.Time `json:"created_at"`
+}
+
+// TicketTransfer moves a ticket to another user once the recipient accepts.
+// The ticket code is reissued on acceptance so the sender's copy stops working.
+type TicketTransfer struct {
+    TransferID  string     `gorm:"primaryKey;size:191" json:"transfer_id"`
+    TicketID    string     `gorm:"not null;size:191;index" json:"ticket_id"`
+    EventID     string     `gorm:"not null;size:191" json:"event_id"`
+    FromUserID  string     `gorm:"not null;size:191;index" json:"from_user_id"`
+    ToUserID    string     `gorm:"not null;size:191;index" json:"to_user_id"`
+    Status      string     `gorm:"default:pending;size:50;index" json:"status"`
+    ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+    RespondedAt *time.Time `json:"responded_at"`
+    CreatedAt   time.Time  `json:"created_at"`
+    UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ResaleListing offers a ticket on the official resale marketplace. It is held
+// while in a buyer's cart and reserved for their order while they pay.
+type ResaleListing struct {
+    ListingID        string     `gorm:"primaryKey;size:191" json:"listing_id"`
+    TicketID         string     `gorm:"not null;size:191;index" json:"ticket_id"`
+    EventID          string     `gorm:"not null;size:191;index" json:"event_id"`
+    TicketCategoryID string     `gorm:"not null;size:191" json:"ticket_category_id"`
+    SellerID         string     `gorm:"not null;size:191;index" json:"seller_id"`
+    Price            float64    `gorm:"not null" json:"price"`
+    Status           string     `gorm:"default:active;size:50;index" json:"status"`
+    OrderID          *string    `gorm:"size:191;index" json:"order_id"`
+    SoldAt           *time.Time `json:"sold_at"`
+    CreatedAt        time.Time  `json:"created_at"`
+    UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// SellerCredit is money owed to a user for a ticket sold on resale.
+type SellerCredit struct {
+    CreditID  string    `gorm:"primaryKey;size:191" json:"credit_id"`
+    UserID    string    `gorm:"not null;size:191;index" json:"user_id"`
+    ListingID string    `gorm:"not null;size:191;unique" json:"listing_id"`
+    OrderID   string    `gorm:"not null;size:191" json:"order_id"`
+    Amount    float64   `gorm:"not null" json:"amount"`
+    Status    string    `gorm:"default:available;size:50" json:"status"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Attendee is the person who will use a ticket. Details given at checkout are
+// stored against the order item until payment mints the ticket.
+type Attendee struct {
+    AttendeeID       string            `gorm:"primaryKey;size:191" json:"attendee_id"`
+    OrderItemID      *string           `gorm:"size:191;index" json:"order_item_id"`
+    Position         int               `gorm:"default:0" json:"position"`
+    TicketID         *string           `gorm:"size:191;uniqueIndex" json:"ticket_id"`
+    EventID          string            `gorm:"not null;size:191;index" json:"event_id"`
+    TicketCategoryID string            `gorm:"not null;size:191" json:"ticket_category_id"`
+    Name             string            `gorm:"not null;size:200" json:"name"`
+    Email            string            `gorm:"size:150" json:"email"`
+    IDNumber         string            `gorm:"size:100" json:"id_number"`
+    CustomFields     map[string]string `gorm:"serializer:json;type:text" json:"custom_fields"`
+    CreatedAt        time.Time         `json:"created_at"`
+    UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// RegistrationQuestion is a question an EO asks buyers, once per order or
+// once per attendee. Without a ticket category it applies to the whole event.
+type RegistrationQuestion struct {
+    QuestionID       string    `gorm:"primaryKey;size:191" json:"question_id"`
+    EventID          string    `gorm:"not null;size:191;index" json:"event_id"`
+    TicketCategoryID *string   `gorm:"size:191" json:"ticket_category_id"`
+    Label            string    `gorm:"not null;size:255" json:"label"`
+    Type             string    `gorm:"not null;size:20" json:"type"`
+    Options          []string  `gorm:"serializer:json;type:text" json:"options"`
+    Required         bool      `gorm:"default:false" json:"required"`
+    Scope            string    `gorm:"default:order;size:20" json:"scope"`
+    MaxLength        int       `gorm:"default:0" json:"max_length"`
+    Pattern          string    `gorm:"size:255" json:"pattern"`
+    Position         int       `gorm:"default:0" json:"position"`
     CreatedAt        time.Time `json:"created_at"`
     UpdatedAt        time.Time `json:"updated_at"`
 }
 
+// EventReschedule records a change of date or venue of an event that already
+// had sales. Holders may ask for a refund until RefundDeadline.
+type EventReschedule struct {
+    RescheduleID      string    `gorm:"primaryKey;size:191" json:"reschedule_id"`
+    EventID           string    `gorm:"not null;size:191;index" json:"event_id"`
+    PreviousDateStart time.Time `json:"previous_date_start"`
+    PreviousDateEnd   time.Time `json:"previous_date_end"`
+    PreviousLocation  string    `json:"previous_location"`
+    DateStart         time.Time `json:"date_start"`
+    DateEnd           time.Time `json:"date_end"`
+    Location          string    `json:"location"`
+    RefundDeadline    time.Time `json:"refund_deadline"`
+    CreatedAt         time.Time `json:"created_at"`
+}
+
+// EventRevision holds changes to the reviewed details of a published event
+// that already sold tickets. The event stays on sale with its current details
+// until an admin approves the revision. An event has at most one pending
+// revision, later edits are merged into it.
+type EventRevision struct {
+    RevisionID    string     `gorm:"primaryKey;size:191" json:"revision_id"`
+    EventID       string     `gorm:"not null;size:191;index" json:"event_id"`
+    Name          string     `gorm:"not null;size:200" json:"name"`
+    DateStart     time.Time  `gorm:"not null" json:"date_start"`
+    DateEnd       time.Time  `gorm:"not null" json:"date_end"`
+    Location      string     `gorm:"not null" json:"location"`
+    Description   string     `gorm:"type:text" json:"description"`
+    Category      string     `gorm:"size:100" json:"category"`
+    Status        string     `gorm:"default:pending;size:50;index" json:"status"`
+    ReviewComment *string    `gorm:"type:text" json:"review_comment"`
+    ReviewedAt    *time.Time `json:"reviewed_at"`
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Refund is money sent back for a paid order, in full or for the tickets of
+// one event. Refunds are queued inside the transaction that decides them and
+// sent to the payment provider afterwards, retrying failures. ClaimedAt is
+// when a worker last started sending it.
+type Refund struct {
+    RefundID          string     `gorm:"primaryKey;size:191" json:"refund_id"`
+    OrderID           string     `gorm:"not null;size:191;index" json:"order_id"`
+    PaymentID         string     `gorm:"not null;size:191" json:"payment_id"`
+    EventID           *string    `gorm:"size:191;index" json:"event_id"`
+    TicketID          *string    `gorm:"size:191;index" json:"ticket_id"`
+    Amount            float64    `gorm:"not null" json:"amount"`
+    Reason            string     `gorm:"not null;size:50" json:"reason"`
+    Status            string     `gorm:"default:pending;size:50;index" json:"status"`
+    Attempts          int        `gorm:"default:0" json:"attempts"`
+    ProviderReference *string    `gorm:"size:191" json:"provider_reference"`
+    FailureReason     *string    `gorm:"type:text" json:"failure_reason"`
+    ClaimedAt         *time.Time `json:"claimed_at"`
+    ProcessedAt       *time.Time `json:"processed_at"`
+    CreatedAt         time.Time  `json:"created_at"`
+    UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// RegistrationAnswer holds the answer to a question for an order, or for one
+// attendee of it when the question is asked per attendee.
+type RegistrationAnswer struct {
+    AnswerID   string    `gorm:"primaryKey;size:191" json:"answer_id"`
+    QuestionID string    `gorm:"not null;size:191;index" json:"question_id"`
+    EventID    string    `gorm:"not null;size:191;index" json:"event_id"`
+    OrderID    string    `gorm:"not null;size:191;index" json:"order_id"`
+    AttendeeID *string   `gorm:"size:191;index" json:"attendee_id"`
+    Values     []string  `gorm:"serializer:json;type:text" json:"values"`
+    CreatedAt  time.Time `json:"created_at"`
+    UpdatedAt  time.Time `json:"updated_at"`
+}
+
 func (user *User) BeforeCreate(tx *gorm.DB) error {
     if user.UserID == "" {
         user.UserID = uuid.New().String()
@@ -142,5 +437,130 @@ func (cart *Cart) BeforeCreate(tx *gorm.DB) error {
     if cart.CartID == "" {
         cart.CartID = uuid.New().String()
     }
+    if cart.ResaleListingID != nil {
+        cart.ItemKey = *cart.ResaleListingID
+    }
+    return nil
+}
+
+func (order *Order) BeforeCreate(tx *gorm.DB) error {
+    if order.OrderID == "" {
+        order.OrderID = uuid.New().String()
+    }
+    return nil
+}
+
+func (item *OrderItem) BeforeCreate(tx *gorm.DB) error {
+    if item.OrderItemID == "" {
+        item.OrderItemID = uuid.New().String()
+    }
     return nil
-}
\ No newline at end of file
+}
+
+func (payment *Payment) BeforeCreate(tx *gorm.DB) error {
+    if payment.PaymentID == "" {
+        payment.PaymentID = uuid.New().String()
+    }
+    return nil
+}
+
+func (key *IdempotencyKey) BeforeCreate(tx *gorm.DB) error {
+    if key.IdempotencyKeyID == "" {
+        key.IdempotencyKeyID = uuid.New().String()
+    }
+    return nil
+}
+
+func (session *Session) BeforeCreate(tx *gorm.DB) error {
+    if session.SessionID == "" {
+        session.SessionID = uuid.New().String()
+    }
+    if session.FamilyID == "" {
+        session.FamilyID = session.SessionID
+    }
+    return nil
+}
+
+func (token *UserToken) BeforeCreate(tx *gorm.DB) error {
+    if token.TokenID == "" {
+        token.TokenID = uuid.New().String()
+    }
+    return nil
+}
+
+func (staff *EventStaff) BeforeCreate(tx *gorm.DB) error {
+    if staff.EventStaffID == "" {
+        staff.EventStaffID = uuid.New().String()
+    }
+    return nil
+}
+
+func (checkIn *CheckIn) BeforeCreate(tx *gorm.DB) error {
+    if checkIn.CheckInID == "" {
+        checkIn.CheckInID = uuid.New().String()
+    }
+    return nil
+}
+
+func (transfer *TicketTransfer) BeforeCreate(tx *gorm.DB) error {
+    if transfer.TransferID == "" {
+        transfer.TransferID = uuid.New().String()
+    }
+    return nil
+}
+
+func (listing *ResaleListing) BeforeCreate(tx *gorm.DB) error {
+    if listing.ListingID == "" {
+        listing.ListingID = uuid.New().String()
+    }
+    return nil
+}
+
+func (credit *SellerCredit) BeforeCreate(tx *gorm.DB) error {
+    if credit.CreditID == "" {
+        credit.CreditID = uuid.New().String()
+    }
+    return nil
+}
+
+func (attendee *Attendee) BeforeCreate(tx *gorm.DB) error {
+    if attendee.AttendeeID == "" {
+        attendee.AttendeeID = uuid.New().String()
+    }
+    return nil
+}
+
+func (question *RegistrationQuestion) BeforeCreate(tx *gorm.DB) error {
+    if question.QuestionID == "" {
+        question.QuestionID = uuid.New().String()
+    }
+    return nil
+}
+
+func (answer *RegistrationAnswer) BeforeCreate(tx *gorm.DB) error {
+    if answer.AnswerID == "" {
+        answer.AnswerID = uuid.New().String()
+    }
+    return nil
+}
+
+func (refund *Refund) BeforeCreate(tx *gorm.DB) error {
+    if refund.RefundID == "" {
+        refund.RefundID = uuid.New().String()
+    }
+    return nil
+}
+
+func (reschedule *EventReschedule) BeforeCreate(tx *gorm.DB) error {
+    if reschedule.RescheduleID == "" {
+        reschedule.RescheduleID = uuid.New().String()
+    }
+    return nil
+}
+
+func (revision *EventRevision) BeforeCreate(tx *gorm.DB) error {
+    if revision.RevisionID == "" {
+        revision.RevisionID = uuid.New().String()
+    }
+    return nil
+}
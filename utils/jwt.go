@@ -3,26 +3,48 @@ package utils
 import (
     "time"
     "os"
+    "strconv"
 
     "github.com/golang-jwt/jwt/v4"
 )
 
 type Claims struct {
-    UserID string `json:"user_id"`
-    Role   string `json:"role"`
+    UserID    string `json:"user_id"`
+    Role      string `json:"role"`
+    SessionID string `json:"sid"`
     jwt.RegisteredClaims
 }
 
-func GenerateJWT(userID, role string) (string, error) {
+// AccessTokenTTL is the lifetime of access tokens, refreshed with a refresh
+// token once they run out.
+func AccessTokenTTL() time.Duration {
+    minutes, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_MINUTES"))
+    if err != nil || minutes <= 0 {
+        minutes = 15
+    }
+    return time.Duration(minutes) * time.Minute
+}
+
+// RefreshTokenTTL is how long a login stays valid without being refreshed.
+func RefreshTokenTTL() time.Duration {
+    days, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_DAYS"))
+    if err != nil || days <= 0 {
+        days = 30
+    }
+    return time.Duration(days) * 24 * time.Hour
+}
+
+func GenerateJWT(userID, role, sessionID string) (string, error) {
     jwtKey := []byte(os.Getenv("JWT_SECRET"))
     if len(jwtKey) == 0 {
         jwtKey = []byte("your-secret-key")
     }
 
-    expirationTime := time.Now().Add(24 * time.Hour)
+    expirationTime := time.Now().Add(AccessTokenTTL())
     claims := &Claims{
-        UserID: userID,
-        Role:   role,
+        UserID:    userID,
+        Role:      role,
+        SessionID: sessionID,
         RegisteredClaims: jwt.RegisteredClaims{
             ExpiresAt: jwt.NewNumericDate(expirationTime),
         },
@@ -52,4 +74,60 @@ func ValidateJWT(tokenString string) (*Claims, error) {
     }
 
     return claims, nil
-}
\ No newline at end of file
+}
+
+// StreamTokenTTL is how long a stream token can be used to open a check-in
+// stream. The stream itself stays open after it runs out.
+const StreamTokenTTL = time.Minute
+
+// StreamClaims let a browser open the check-in stream of one event. They are
+// signed with a key of their own, so they never pass as an access token.
+type StreamClaims struct {
+    UserID    string `json:"user_id"`
+    Role      string `json:"role"`
+    SessionID string `json:"sid"`
+    EventID   string `json:"event_id"`
+    jwt.RegisteredClaims
+}
+
+func streamTokenKey() []byte {
+    jwtKey := []byte(os.Getenv("JWT_SECRET"))
+    if len(jwtKey) == 0 {
+        jwtKey = []byte("your-secret-key")
+    }
+    return append([]byte("checkin-stream:"), jwtKey...)
+}
+
+func GenerateStreamToken(userID, role, sessionID, eventID string) (string, time.Time, error) {
+    expirationTime := time.Now().Add(StreamTokenTTL)
+    claims := &StreamClaims{
+        UserID:    userID,
+        Role:      role,
+        SessionID: sessionID,
+        EventID:   eventID,
+        RegisteredClaims: jwt.RegisteredClaims{
+            ExpiresAt: jwt.NewNumericDate(expirationTime),
+        },
+    }
+
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    signed, err := token.SignedString(streamTokenKey())
+    return signed, expirationTime, err
+}
+
+func ValidateStreamToken(tokenString string) (*StreamClaims, error) {
+    claims := &StreamClaims{}
+    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+        return streamTokenKey(), nil
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    if !token.Valid {
+        return nil, jwt.ErrSignatureInvalid
+    }
+
+    return claims, nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+// TestStreamTokensAreNotAccessTokens checks that a stream token only opens a
+// stream: it must not pass as an access token, nor an access token as it.
+func TestStreamTokensAreNotAccessTokens(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test-secret")
+
+    stream, _, err := GenerateStreamToken("user", "eo", "session", "event")
+    if err != nil {
+        t.Fatal(err)
+    }
+    claims, err := ValidateStreamToken(stream)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if claims.UserID != "user" || claims.SessionID != "session" || claims.EventID != "event" {
+        t.Errorf("stream claims = %+v", claims)
+    }
+    if _, err := ValidateJWT(stream); err == nil {
+        t.Error("stream token accepted as an access token")
+    }
+
+    access, err := GenerateJWT("user", "eo", "session")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ValidateStreamToken(access); err == nil {
+        t.Error("access token accepted as a stream token")
+    }
+}
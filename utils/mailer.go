@@ -0,0 +1,162 @@
+package utils
+
+import (
+    "encoding/base64"
+    "fmt"
+    "log"
+    "mime"
+    "net/smtp"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+type Message struct {
+    To          string
+    Subject     string
+    Body        string
+    Attachments []Attachment
+}
+
+// Attachment is a file sent along with an email, such as a PDF ticket.
+type Attachment struct {
+    Filename    string
+    ContentType string
+    Data        []byte
+}
+
+// Mailer sends transactional emails such as password resets.
+type Mailer interface {
+    Send(msg Message) error
+}
+
+var Mail Mailer
+
+// NewMailer builds the mailer selected by MAIL_DRIVER: "smtp", "file" or the
+// default "log" which only prints emails for local development.
+func NewMailer() Mailer {
+    switch os.Getenv("MAIL_DRIVER") {
+    case "smtp":
+        return &SMTPMailer{
+            Host:     os.Getenv("SMTP_HOST"),
+            Port:     os.Getenv("SMTP_PORT"),
+            Username: os.Getenv("SMTP_USERNAME"),
+            Password: os.Getenv("SMTP_PASSWORD"),
+            From:     os.Getenv("MAIL_FROM"),
+        }
+    case "file":
+        path := os.Getenv("MAIL_FILE")
+        if path == "" {
+            path = "mail.log"
+        }
+        return &FileMailer{Path: path}
+    default:
+        return LogMailer{}
+    }
+}
+
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+    log.Printf("Mail to %s: %s\n%s%s", msg.To, msg.Subject, msg.Body, attachmentList(msg))
+    return nil
+}
+
+func attachmentList(msg Message) string {
+    list := ""
+    for _, attachment := range msg.Attachments {
+        list += fmt.Sprintf("\n[attachment: %s, %s, %d bytes]", attachment.Filename, attachment.ContentType, len(attachment.Data))
+    }
+    return list
+}
+
+// FileMailer appends every email to a file so links can be copied from it.
+type FileMailer struct {
+    Path string
+    mu   sync.Mutex
+}
+
+func (m *FileMailer) Send(msg Message) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    _, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s%s\n\n----\n\n",
+        time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body, attachmentList(msg))
+    return err
+}
+
+var headerBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// headerValue keeps a value on one header line. Subjects carry user input such
+// as event names, which must not be able to add headers or recipients.
+func headerValue(value string) string {
+    return headerBreaks.Replace(value)
+}
+
+type SMTPMailer struct {
+    Host     string
+    Port     string
+    Username string
+    Password string
+    From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+    var auth smtp.Auth
+    if m.Username != "" {
+        auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+    }
+
+    to := headerValue(msg.To)
+    headers := []string{
+        "From: " + m.From,
+        "To: " + to,
+        "Subject: " + mime.QEncoding.Encode("UTF-8", headerValue(msg.Subject)),
+        "MIME-Version: 1.0",
+    }
+
+    if len(msg.Attachments) == 0 {
+        headers = append(headers, "Content-Type: text/plain; charset=UTF-8")
+        body := strings.Join(headers, "\r\n") + "\r\n\r\n" + msg.Body
+        return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(body))
+    }
+
+    token, err := GenerateOpaqueToken()
+    if err != nil {
+        return err
+    }
+    boundary := "ticketing-" + token[:24]
+    headers = append(headers, "Content-Type: multipart/mixed; boundary=\""+boundary+"\"")
+
+    var body strings.Builder
+    body.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+    body.WriteString("--" + boundary + "\r\n")
+    body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+    body.WriteString(msg.Body + "\r\n")
+
+    for _, attachment := range msg.Attachments {
+        filename := strings.ReplaceAll(headerValue(attachment.Filename), "\"", "")
+        body.WriteString("--" + boundary + "\r\n")
+        body.WriteString("Content-Type: " + attachment.ContentType + "; name=\"" + filename + "\"\r\n")
+        body.WriteString("Content-Transfer-Encoding: base64\r\n")
+        body.WriteString("Content-Disposition: attachment; filename=\"" + filename + "\"\r\n\r\n")
+
+        // Lines of base64 must stay under the 998 character SMTP limit
+        encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+        for len(encoded) > 76 {
+            body.WriteString(encoded[:76] + "\r\n")
+            encoded = encoded[76:]
+        }
+        body.WriteString(encoded + "\r\n")
+    }
+    body.WriteString("--" + boundary + "--\r\n")
+
+    return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(body.String()))
+}
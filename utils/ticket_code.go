@@ -0,0 +1,126 @@
+package utils
+
+import (
+    "crypto/ed25519"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/pem"
+    "errors"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Ticket codes look like "T1.<base64url(payload || signature)>". The payload
+// is version (1 byte), ticket ID, event ID and ticket category ID (16 bytes
+// each, raw UUIDs) and the issue time (8 bytes, unix seconds, big endian).
+// The signature is Ed25519 over the payload, so gate devices holding the
+// public key can validate a code without calling the API.
+const (
+    ticketCodePrefix  = "T1."
+    ticketCodeVersion = 1
+    ticketPayloadSize = 1 + 16*3 + 8
+)
+
+var (
+    ErrInvalidTicketCode = errors.New("invalid ticket code")
+    ErrNoTicketKey       = errors.New("ticket signing key is not loaded")
+)
+
+type TicketCodePayload struct {
+    TicketID         string    `json:"ticket_id"`
+    EventID          string    `json:"event_id"`
+    TicketCategoryID string    `json:"ticket_category_id"`
+    IssuedAt         time.Time `json:"issued_at"`
+}
+
+var ticketKey ed25519.PrivateKey
+
+// LoadTicketSigningKey loads the Ed25519 seed from TICKET_SIGNING_KEY (base64).
+// There is deliberately no fallback key: gate devices admit any code that
+// verifies offline, so a key anyone can derive would let anyone mint tickets.
+func LoadTicketSigningKey() error {
+    seed, err := base64.StdEncoding.DecodeString(os.Getenv("TICKET_SIGNING_KEY"))
+    if err != nil || len(seed) != ed25519.SeedSize {
+        return errors.New("TICKET_SIGNING_KEY must be a base64 encoded 32 byte seed")
+    }
+    ticketKey = ed25519.NewKeyFromSeed(seed)
+    return nil
+}
+
+// TicketSigningPublicKey is nil until LoadTicketSigningKey succeeded.
+func TicketSigningPublicKey() ed25519.PublicKey {
+    if ticketKey == nil {
+        return nil
+    }
+    return ticketKey.Public().(ed25519.PublicKey)
+}
+
+func TicketSigningPublicKeyPEM() (string, error) {
+    if ticketKey == nil {
+        return "", ErrNoTicketKey
+    }
+    der, err := x509.MarshalPKIXPublicKey(TicketSigningPublicKey())
+    if err != nil {
+        return "", err
+    }
+    return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func SignTicketCode(payload TicketCodePayload) (string, error) {
+    if ticketKey == nil {
+        return "", ErrNoTicketKey
+    }
+
+    buf := make([]byte, 0, ticketPayloadSize+ed25519.SignatureSize)
+    buf = append(buf, ticketCodeVersion)
+
+    for _, id := range []string{payload.TicketID, payload.EventID, payload.TicketCategoryID} {
+        parsed, err := uuid.Parse(id)
+        if err != nil {
+            return "", err
+        }
+        buf = append(buf, parsed[:]...)
+    }
+
+    buf = binary.BigEndian.AppendUint64(buf, uint64(payload.IssuedAt.Unix()))
+    buf = append(buf, ed25519.Sign(ticketKey, buf)...)
+
+    return ticketCodePrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func VerifyTicketCode(code string) (*TicketCodePayload, error) {
+    if ticketKey == nil {
+        return nil, ErrNoTicketKey
+    }
+    if !strings.HasPrefix(code, ticketCodePrefix) {
+        return nil, ErrInvalidTicketCode
+    }
+
+    raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(code, ticketCodePrefix))
+    if err != nil || len(raw) != ticketPayloadSize+ed25519.SignatureSize || raw[0] != ticketCodeVersion {
+        return nil, ErrInvalidTicketCode
+    }
+
+    payload, signature := raw[:ticketPayloadSize], raw[ticketPayloadSize:]
+    if !ed25519.Verify(TicketSigningPublicKey(), payload, signature) {
+        return nil, ErrInvalidTicketCode
+    }
+
+    ids := make([]string, 3)
+    for i := range ids {
+        var id uuid.UUID
+        copy(id[:], payload[1+16*i:1+16*(i+1)])
+        ids[i] = id.String()
+    }
+
+    return &TicketCodePayload{
+        TicketID:         ids[0],
+        EventID:          ids[1],
+        TicketCategoryID: ids[2],
+        IssuedAt:         time.Unix(int64(binary.BigEndian.Uint64(payload[49:])), 0),
+    }, nil
+}
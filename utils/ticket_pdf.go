@@ -0,0 +1,133 @@
+package utils
+
+import (
+    "bytes"
+    "time"
+
+    "github.com/jung-kurt/gofpdf"
+    "github.com/skip2/go-qrcode"
+)
+
+// TicketPDF is everything printed on one page of an e-ticket.
+type TicketPDF struct {
+    TicketID     string
+    Code         string
+    EventName    string
+    DateStart    time.Time
+    DateEnd      time.Time
+    Location     string
+    Category     string
+    AttendeeName string
+    IssuedAt     time.Time
+}
+
+const ticketPDFDateFormat = "Mon, 2 Jan 2006 15:04"
+
+// RenderTicketsPDF lays out one A4 page per ticket. The document dates are
+// taken from the tickets rather than the clock, so the same tickets always
+// render to the same bytes.
+func RenderTicketsPDF(title string, tickets []TicketPDF) ([]byte, error) {
+    pdf := gofpdf.New("P", "mm", "A4", "")
+    pdf.SetTitle(title, true)
+    pdf.SetCreator("Ticketing", true)
+    pdf.SetCatalogSort(true)
+    pdf.SetAutoPageBreak(false, 0)
+
+    var issuedAt time.Time
+    for _, ticket := range tickets {
+        if ticket.IssuedAt.After(issuedAt) {
+            issuedAt = ticket.IssuedAt
+        }
+    }
+    pdf.SetCreationDate(issuedAt)
+    pdf.SetModificationDate(issuedAt)
+
+    // Core fonts only cover cp1252, so names are translated before printing
+    tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+    for _, ticket := range tickets {
+        pdf.AddPage()
+
+        pdf.SetDrawColor(40, 40, 40)
+        pdf.SetLineWidth(0.4)
+        pdf.Rect(15, 15, 180, 120, "D")
+
+        pdf.SetXY(22, 22)
+        pdf.SetFont("Helvetica", "B", 20)
+        pdf.MultiCell(100, 9, tr(ticket.EventName), "", "L", false)
+
+        pdf.SetY(pdf.GetY() + 4)
+        rows := [][2]string{
+            {"Date", ticket.DateStart.Format(ticketPDFDateFormat) + " - " + ticket.DateEnd.Format(ticketPDFDateFormat)},
+            {"Location", ticket.Location},
+            {"Category", ticket.Category},
+            {"Attendee", ticket.AttendeeName},
+        }
+        for _, row := range rows {
+            if row[1] == "" {
+                continue
+            }
+            pdf.SetX(22)
+            pdf.SetFont("Helvetica", "", 9)
+            pdf.SetTextColor(110, 110, 110)
+            pdf.CellFormat(100, 5, row[0], "", 1, "L", false, 0, "")
+            pdf.SetX(22)
+            pdf.SetFont("Helvetica", "B", 12)
+            pdf.SetTextColor(0, 0, 0)
+            pdf.MultiCell(100, 6, tr(row[1]), "", "L", false)
+            pdf.SetY(pdf.GetY() + 2)
+        }
+
+        if err := drawQRCode(pdf, ticket.Code, 130, 25, 58); err != nil {
+            return nil, err
+        }
+
+        pdf.SetXY(130, 86)
+        pdf.SetFont("Helvetica", "", 8)
+        pdf.SetTextColor(110, 110, 110)
+        pdf.MultiCell(58, 4, "Ticket "+ticket.TicketID, "", "C", false)
+
+        pdf.SetXY(22, 120)
+        pdf.SetFont("Helvetica", "", 8)
+        pdf.MultiCell(166, 4, "Show this QR code at the entrance. It is only valid for the current holder; "+
+            "a transferred or resold ticket gets a new code.", "", "L", false)
+    }
+
+    if err := pdf.Error(); err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    if err := pdf.Output(&buf); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// drawQRCode draws the code as filled squares rather than an embedded image.
+// gofpdf writes images of equal size in map order, which would make the bytes
+// of a multi-ticket PDF differ between renders.
+func drawQRCode(pdf *gofpdf.Fpdf, code string, x, y, size float64) error {
+    qr, err := qrcode.New(code, qrcode.Medium)
+    if err != nil {
+        return err
+    }
+    bitmap := qr.Bitmap()
+    module := size / float64(len(bitmap))
+
+    pdf.SetFillColor(0, 0, 0)
+    for row, modules := range bitmap {
+        // Runs of dark modules on a row are drawn as one rectangle
+        for col := 0; col < len(modules); col++ {
+            if !modules[col] {
+                continue
+            }
+            start := col
+            for col+1 < len(modules) && modules[col+1] {
+                col++
+            }
+            pdf.Rect(x+float64(start)*module, y+float64(row)*module, float64(col-start+1)*module, module, "F")
+        }
+    }
+    return nil
+}
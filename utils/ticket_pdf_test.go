@@ -0,0 +1,91 @@
+package utils
+
+import (
+    "bytes"
+    "flag"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenTickets covers a single ticket with every field and an order whose
+// second ticket has no location or attendee and a name outside ASCII.
+var goldenTickets = map[string][]TicketPDF{
+    "ticket_pdf_single.golden": {{
+        TicketID:     "0b6f3a52-3c1e-4d6a-9d2b-7a1e5f0c9e11",
+        Code:         "T1.AQsfOlI8Hk1qnStqHl8MnhF",
+        EventName:    "Jakarta Jazz Night",
+        DateStart:    time.Date(2026, 11, 20, 19, 0, 0, 0, time.UTC),
+        DateEnd:      time.Date(2026, 11, 20, 23, 0, 0, 0, time.UTC),
+        Location:     "Istora Senayan, Jakarta",
+        Category:     "VIP",
+        AttendeeName: "Rina Wulandari",
+        IssuedAt:     time.Date(2026, 10, 1, 8, 30, 0, 0, time.UTC),
+    }},
+    "ticket_pdf_order.golden": {
+        {
+            TicketID:  "5d0c2f4e-8a7b-4c3d-9e1f-2a3b4c5d6e7f",
+            Code:      "T1.XQwvTop7TD2eHyo7TF1ufw",
+            EventName: "Café Sessions: Über Acoustic",
+            DateStart: time.Date(2026, 12, 5, 18, 30, 0, 0, time.UTC),
+            DateEnd:   time.Date(2026, 12, 6, 1, 0, 0, 0, time.UTC),
+            Category:  "General Admission",
+            IssuedAt:  time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC),
+        },
+        {
+            TicketID:     "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d",
+            Code:         "T1.mot8bV5PSjuMLR4Pmot8bQ",
+            EventName:    "Café Sessions: Über Acoustic",
+            DateStart:    time.Date(2026, 12, 5, 18, 30, 0, 0, time.UTC),
+            DateEnd:      time.Date(2026, 12, 6, 1, 0, 0, 0, time.UTC),
+            Location:     "Bandung",
+            Category:     "General Admission",
+            AttendeeName: "Jürgen Müller",
+            IssuedAt:     time.Date(2026, 10, 2, 9, 5, 0, 0, time.UTC),
+        },
+    },
+}
+
+func TestRenderTicketsPDFGolden(t *testing.T) {
+    for name, tickets := range goldenTickets {
+        t.Run(name, func(t *testing.T) {
+            got, err := RenderTicketsPDF("Tickets", tickets)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            path := filepath.Join("testdata", name)
+            if *updateGolden {
+                if err := os.WriteFile(path, got, 0644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            want, err := os.ReadFile(path)
+            if err != nil {
+                t.Fatalf("%v (run go test ./utils -run TestRenderTicketsPDFGolden -update to create it)", err)
+            }
+            if !bytes.Equal(got, want) {
+                t.Errorf("rendered PDF differs from %s (%d bytes, want %d); if the change is intended, run with -update", path, len(got), len(want))
+            }
+        })
+    }
+}
+
+func TestRenderTicketsPDFDeterministic(t *testing.T) {
+    tickets := goldenTickets["ticket_pdf_order.golden"]
+    first, err := RenderTicketsPDF("Tickets", tickets)
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := RenderTicketsPDF("Tickets", tickets)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(first, second) {
+        t.Error("rendering the same tickets twice gave different bytes")
+    }
+}
@@ -0,0 +1,418 @@
+package utils
+
+import (
+    "archive/zip"
+    "bytes"
+    "crypto"
+    "crypto/rsa"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "image"
+    "image/color"
+    "image/png"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/golang-jwt/jwt/v4"
+    "go.mozilla.org/pkcs7"
+)
+
+// WalletPass is what a ticket shows once it is added to a phone wallet.
+type WalletPass struct {
+    TicketID     string
+    Code         string
+    EventID      string
+    EventName    string
+    DateStart    time.Time
+    DateEnd      time.Time
+    Location     string
+    Category     string
+    AttendeeName string
+}
+
+// AppleWallet and GoogleWallet stay nil until their credentials are configured.
+var (
+    AppleWallet  *AppleWalletSigner
+    GoogleWallet *GoogleWalletSigner
+)
+
+// AppleWalletSigner builds signed .pkpass bundles with the pass type
+// certificate issued by Apple for APPLE_PASS_TYPE_ID.
+type AppleWalletSigner struct {
+    PassTypeID       string
+    TeamID           string
+    OrganizationName string
+    cert             *x509.Certificate
+    key              crypto.PrivateKey
+    wwdr             *x509.Certificate
+    assets           map[string][]byte
+}
+
+// NewAppleWalletSigner loads the pass certificate, its key and the Apple WWDR
+// intermediate from the PEM files named by APPLE_PASS_CERT, APPLE_PASS_KEY and
+// APPLE_WWDR_CERT. PNG images in APPLE_PASS_ASSETS (icon.png, logo.png, ...)
+// are added to every pass. Returns nil when Apple Wallet is not configured.
+func NewAppleWalletSigner() (*AppleWalletSigner, error) {
+    passTypeID := os.Getenv("APPLE_PASS_TYPE_ID")
+    if passTypeID == "" {
+        return nil, nil
+    }
+
+    teamID := os.Getenv("APPLE_TEAM_ID")
+    if teamID == "" {
+        return nil, errors.New("APPLE_TEAM_ID is not set")
+    }
+
+    cert, err := loadCertificate(os.Getenv("APPLE_PASS_CERT"))
+    if err != nil {
+        return nil, err
+    }
+    wwdr, err := loadCertificate(os.Getenv("APPLE_WWDR_CERT"))
+    if err != nil {
+        return nil, err
+    }
+    key, err := loadPrivateKey(os.Getenv("APPLE_PASS_KEY"))
+    if err != nil {
+        return nil, err
+    }
+
+    organization := os.Getenv("APPLE_PASS_ORGANIZATION")
+    if organization == "" {
+        organization = "Ticketing"
+    }
+
+    assets := map[string][]byte{}
+    if dir := os.Getenv("APPLE_PASS_ASSETS"); dir != "" {
+        paths, err := filepath.Glob(filepath.Join(dir, "*.png"))
+        if err != nil {
+            return nil, err
+        }
+        for _, path := range paths {
+            data, err := os.ReadFile(path)
+            if err != nil {
+                return nil, err
+            }
+            assets[filepath.Base(path)] = data
+        }
+    }
+    // Wallet refuses passes without an icon
+    if _, ok := assets["icon.png"]; !ok {
+        assets["icon.png"] = defaultPassIcon()
+    }
+
+    return &AppleWalletSigner{
+        PassTypeID:       passTypeID,
+        TeamID:           teamID,
+        OrganizationName: organization,
+        cert:             cert,
+        key:              key,
+        wwdr:             wwdr,
+        assets:           assets,
+    }, nil
+}
+
+type passField struct {
+    Key       string `json:"key"`
+    Label     string `json:"label"`
+    Value     string `json:"value"`
+    DateStyle string `json:"dateStyle,omitempty"`
+    TimeStyle string `json:"timeStyle,omitempty"`
+}
+
+type passBarcode struct {
+    Format          string `json:"format"`
+    Message         string `json:"message"`
+    MessageEncoding string `json:"messageEncoding"`
+}
+
+// BuildPass returns the zipped pass: pass.json, the images, a manifest of
+// their SHA-1 hashes and a detached PKCS#7 signature of the manifest.
+func (s *AppleWalletSigner) BuildPass(pass WalletPass) ([]byte, error) {
+    primary := []passField{{Key: "event", Label: "EVENT", Value: pass.EventName}}
+    secondary := []passField{{
+        Key:       "date",
+        Label:     "DATE",
+        Value:     pass.DateStart.Format(time.RFC3339),
+        DateStyle: "PKDateStyleMedium",
+        TimeStyle: "PKDateStyleShort",
+    }}
+    if pass.Location != "" {
+        secondary = append(secondary, passField{Key: "location", Label: "LOCATION", Value: pass.Location})
+    }
+    auxiliary := []passField{{Key: "category", Label: "TICKET", Value: pass.Category}}
+    if pass.AttendeeName != "" {
+        auxiliary = append(auxiliary, passField{Key: "attendee", Label: "ATTENDEE", Value: pass.AttendeeName})
+    }
+    back := []passField{
+        {Key: "ticket", Label: "Ticket number", Value: pass.TicketID},
+        {Key: "terms", Label: "Note", Value: "This pass is only valid for the current holder. A transferred or resold ticket gets a new code; download the pass again after it changes hands."},
+    }
+
+    passJSON, err := json.Marshal(map[string]interface{}{
+        "formatVersion":      1,
+        "passTypeIdentifier": s.PassTypeID,
+        "teamIdentifier":     s.TeamID,
+        "organizationName":   s.OrganizationName,
+        "serialNumber":       pass.TicketID,
+        "description":        "Ticket for " + pass.EventName,
+        "relevantDate":       pass.DateStart.Format(time.RFC3339),
+        "expirationDate":     pass.DateEnd.Format(time.RFC3339),
+        "barcodes": []passBarcode{{
+            Format:          "PKBarcodeFormatQR",
+            Message:         pass.Code,
+            MessageEncoding: "iso-8859-1",
+        }},
+        "eventTicket": map[string][]passField{
+            "primaryFields":   primary,
+            "secondaryFields": secondary,
+            "auxiliaryFields": auxiliary,
+            "backFields":      back,
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    files := map[string][]byte{"pass.json": passJSON}
+    for name, data := range s.assets {
+        files[name] = data
+    }
+
+    manifest := map[string]string{}
+    for name, data := range files {
+        sum := sha1.Sum(data)
+        manifest[name] = hex.EncodeToString(sum[:])
+    }
+    manifestJSON, err := json.Marshal(manifest)
+    if err != nil {
+        return nil, err
+    }
+    files["manifest.json"] = manifestJSON
+
+    signed, err := pkcs7.NewSignedData(manifestJSON)
+    if err != nil {
+        return nil, err
+    }
+    signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+    if err := signed.AddSignerChain(s.cert, s.key, []*x509.Certificate{s.wwdr}, pkcs7.SignerInfoConfig{}); err != nil {
+        return nil, err
+    }
+    signed.Detach()
+    signature, err := signed.Finish()
+    if err != nil {
+        return nil, err
+    }
+    files["signature"] = signature
+
+    names := make([]string, 0, len(files))
+    for name := range files {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var buf bytes.Buffer
+    archive := zip.NewWriter(&buf)
+    for _, name := range names {
+        w, err := archive.Create(name)
+        if err != nil {
+            return nil, err
+        }
+        if _, err := w.Write(files[name]); err != nil {
+            return nil, err
+        }
+    }
+    if err := archive.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// defaultPassIcon is a plain 58x58 square used when no icon is configured.
+func defaultPassIcon() []byte {
+    img := image.NewRGBA(image.Rect(0, 0, 58, 58))
+    for x := 0; x < 58; x++ {
+        for y := 0; y < 58; y++ {
+            img.Set(x, y, color.RGBA{40, 40, 40, 255})
+        }
+    }
+    var buf bytes.Buffer
+    png.Encode(&buf, img)
+    return buf.Bytes()
+}
+
+// GoogleWalletSaveURL followed by a signed JWT opens the pass in Google Wallet.
+const GoogleWalletSaveURL = "https://pay.google.com/gp/v/save/"
+
+// GoogleWalletSigner signs "Save to Google Wallet" links with a service
+// account of the issuer account GOOGLE_WALLET_ISSUER_ID.
+type GoogleWalletSigner struct {
+    IssuerID       string
+    IssuerName     string
+    ServiceAccount string
+    Origins        []string
+    key            *rsa.PrivateKey
+}
+
+// NewGoogleWalletSigner loads the service account key file named by
+// GOOGLE_WALLET_SERVICE_ACCOUNT, as downloaded from the Google Cloud console.
+// Returns nil when Google Wallet is not configured.
+func NewGoogleWalletSigner(origins []string) (*GoogleWalletSigner, error) {
+    issuerID := os.Getenv("GOOGLE_WALLET_ISSUER_ID")
+    if issuerID == "" {
+        return nil, nil
+    }
+
+    data, err := os.ReadFile(os.Getenv("GOOGLE_WALLET_SERVICE_ACCOUNT"))
+    if err != nil {
+        return nil, err
+    }
+    var account struct {
+        ClientEmail string `json:"client_email"`
+        PrivateKey  string `json:"private_key"`
+    }
+    if err := json.Unmarshal(data, &account); err != nil {
+        return nil, err
+    }
+    if account.ClientEmail == "" {
+        return nil, errors.New("service account key has no client_email")
+    }
+
+    key, err := parsePrivateKey([]byte(account.PrivateKey))
+    if err != nil {
+        return nil, err
+    }
+    rsaKey, ok := key.(*rsa.PrivateKey)
+    if !ok {
+        return nil, errors.New("service account key is not an RSA key")
+    }
+
+    issuerName := os.Getenv("GOOGLE_WALLET_ISSUER_NAME")
+    if issuerName == "" {
+        issuerName = "Ticketing"
+    }
+
+    return &GoogleWalletSigner{
+        IssuerID:       issuerID,
+        IssuerName:     issuerName,
+        ServiceAccount: account.ClientEmail,
+        Origins:        origins,
+        key:            rsaKey,
+    }, nil
+}
+
+type walletText struct {
+    DefaultValue struct {
+        Language string `json:"language"`
+        Value    string `json:"value"`
+    } `json:"defaultValue"`
+}
+
+func localized(value string) walletText {
+    var text walletText
+    text.DefaultValue.Language = "en-US"
+    text.DefaultValue.Value = value
+    return text
+}
+
+// SaveJWT returns the signed JWT carrying the event class and the ticket
+// object. The object ID includes a hash of the code, so a ticket whose code is
+// reissued is saved as a new object instead of reusing the stale one.
+func (s *GoogleWalletSigner) SaveJWT(pass WalletPass) (string, error) {
+    codeHash := sha256.Sum256([]byte(pass.Code))
+    classID := s.IssuerID + "." + pass.EventID
+    objectID := s.IssuerID + "." + pass.TicketID + "-" + hex.EncodeToString(codeHash[:4])
+
+    class := map[string]interface{}{
+        "id":           classID,
+        "issuerName":   s.IssuerName,
+        "reviewStatus": "UNDER_REVIEW",
+        "eventName":    localized(pass.EventName),
+        "dateTime": map[string]string{
+            "start": pass.DateStart.Format(time.RFC3339),
+            "end":   pass.DateEnd.Format(time.RFC3339),
+        },
+    }
+    if pass.Location != "" {
+        class["venue"] = map[string]walletText{
+            "name":    localized(pass.Location),
+            "address": localized(pass.Location),
+        }
+    }
+
+    object := map[string]interface{}{
+        "id":           objectID,
+        "classId":      classID,
+        "state":        "ACTIVE",
+        "ticketNumber": pass.TicketID,
+        "ticketType":   localized(pass.Category),
+        "barcode": map[string]string{
+            "type":  "QR_CODE",
+            "value": pass.Code,
+        },
+        "validTimeInterval": map[string]interface{}{
+            "end": map[string]string{"date": pass.DateEnd.Format(time.RFC3339)},
+        },
+    }
+    if pass.AttendeeName != "" {
+        object["ticketHolderName"] = pass.AttendeeName
+    }
+
+    claims := jwt.MapClaims{
+        "iss":     s.ServiceAccount,
+        "aud":     "google",
+        "typ":     "savetowallet",
+        "iat":     time.Now().Unix(),
+        "origins": s.Origins,
+        "payload": map[string]interface{}{
+            "eventTicketClasses": []interface{}{class},
+            "eventTicketObjects": []interface{}{object},
+        },
+    }
+
+    return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(s.key)
+}
+
+func loadCertificate(path string) (*x509.Certificate, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil || block.Type != "CERTIFICATE" {
+        return nil, errors.New(path + " does not contain a PEM certificate")
+    }
+    return x509.ParseCertificate(block.Bytes)
+}
+
+func loadPrivateKey(path string) (crypto.PrivateKey, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return parsePrivateKey(data)
+}
+
+// parsePrivateKey accepts PKCS#8, PKCS#1 and SEC 1 PEM keys.
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+    block, _ := pem.Decode(data)
+    if block == nil || !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+        return nil, errors.New("no PEM private key found")
+    }
+
+    switch block.Type {
+    case "RSA PRIVATE KEY":
+        return x509.ParsePKCS1PrivateKey(block.Bytes)
+    case "EC PRIVATE KEY":
+        return x509.ParseECPrivateKey(block.Bytes)
+    default:
+        return x509.ParsePKCS8PrivateKey(block.Bytes)
+    }
+}
@@ -0,0 +1,269 @@
+package utils
+
+import (
+    "archive/zip"
+    "bytes"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha1"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/pem"
+    "io"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v4"
+    "go.mozilla.org/pkcs7"
+)
+
+var walletTestPass = WalletPass{
+    TicketID:     "0b6f3a52-3c1e-4d6a-9d2b-7a1e5f0c9e11",
+    Code:         "T1.AQsfOlI8Hk1qnStqHl8MnhF",
+    EventID:      "7c1d2e3f-4a5b-4c6d-8e9f-0a1b2c3d4e5f",
+    EventName:    "Jakarta Jazz Night",
+    DateStart:    time.Date(2026, 11, 20, 19, 0, 0, 0, time.UTC),
+    DateEnd:      time.Date(2026, 11, 20, 23, 0, 0, 0, time.UTC),
+    Location:     "Istora Senayan, Jakarta",
+    Category:     "VIP",
+    AttendeeName: "Rina Wulandari",
+}
+
+// writePEM writes one PEM block to a file in dir and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+    t.Helper()
+    path := filepath.Join(dir, name)
+    if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+// newTestCertificate issues a certificate for key, self-signed when parent is
+// nil. CA certificates can sign the pass certificate like Apple's WWDR does.
+func newTestCertificate(t *testing.T, name string, serial int64, key *rsa.PrivateKey, parent *x509.Certificate, parentKey *rsa.PrivateKey, isCA bool) *x509.Certificate {
+    t.Helper()
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(serial),
+        Subject:               pkix.Name{CommonName: name},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(24 * time.Hour),
+        KeyUsage:              x509.KeyUsageDigitalSignature,
+        BasicConstraintsValid: true,
+        IsCA:                  isCA,
+    }
+    if isCA {
+        template.KeyUsage |= x509.KeyUsageCertSign
+    }
+    if parent == nil {
+        parent, parentKey = template, key
+    }
+
+    der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cert
+}
+
+func TestAppleWalletBuildPass(t *testing.T) {
+    wwdrKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    passKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    wwdr := newTestCertificate(t, "Test WWDR", 1, wwdrKey, nil, nil, true)
+    passCert := newTestCertificate(t, "Pass Type ID: pass.test.ticket", 2, passKey, wwdr, wwdrKey, false)
+
+    dir := t.TempDir()
+    t.Setenv("APPLE_PASS_TYPE_ID", "pass.test.ticket")
+    t.Setenv("APPLE_TEAM_ID", "TEAM123456")
+    t.Setenv("APPLE_PASS_ORGANIZATION", "")
+    t.Setenv("APPLE_PASS_ASSETS", "")
+    t.Setenv("APPLE_WWDR_CERT", writePEM(t, dir, "wwdr.pem", "CERTIFICATE", wwdr.Raw))
+    t.Setenv("APPLE_PASS_CERT", writePEM(t, dir, "pass.pem", "CERTIFICATE", passCert.Raw))
+    t.Setenv("APPLE_PASS_KEY", writePEM(t, dir, "pass.key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(passKey)))
+
+    signer, err := NewAppleWalletSigner()
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := signer.BuildPass(walletTestPass)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    files := map[string][]byte{}
+    for _, f := range archive.File {
+        r, err := f.Open()
+        if err != nil {
+            t.Fatal(err)
+        }
+        content, err := io.ReadAll(r)
+        r.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        files[f.Name] = content
+    }
+    for _, name := range []string{"pass.json", "icon.png", "manifest.json", "signature"} {
+        if _, ok := files[name]; !ok {
+            t.Fatalf("pass is missing %s", name)
+        }
+    }
+
+    var pass struct {
+        PassTypeIdentifier string        `json:"passTypeIdentifier"`
+        TeamIdentifier     string        `json:"teamIdentifier"`
+        SerialNumber       string        `json:"serialNumber"`
+        Barcodes           []passBarcode `json:"barcodes"`
+    }
+    if err := json.Unmarshal(files["pass.json"], &pass); err != nil {
+        t.Fatal(err)
+    }
+    if pass.PassTypeIdentifier != "pass.test.ticket" || pass.TeamIdentifier != "TEAM123456" || pass.SerialNumber != walletTestPass.TicketID {
+        t.Errorf("pass.json identifiers = %+v", pass)
+    }
+    if len(pass.Barcodes) != 1 || pass.Barcodes[0].Message != walletTestPass.Code {
+        t.Errorf("pass.json barcodes = %+v, want the ticket code", pass.Barcodes)
+    }
+
+    // Every file but the manifest and signature is listed with its SHA-1
+    var manifest map[string]string
+    if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+        t.Fatal(err)
+    }
+    if len(manifest) != len(files)-2 {
+        t.Errorf("manifest lists %d files, want %d", len(manifest), len(files)-2)
+    }
+    for name, content := range files {
+        if name == "manifest.json" || name == "signature" {
+            continue
+        }
+        sum := sha1.Sum(content)
+        if manifest[name] != hex.EncodeToString(sum[:]) {
+            t.Errorf("manifest hash of %s = %q, want %x", name, manifest[name], sum)
+        }
+    }
+
+    // The signature is detached, so the manifest is supplied before verifying
+    p7, err := pkcs7.Parse(files["signature"])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(p7.Content) != 0 {
+        t.Error("signature embeds the manifest, want a detached signature")
+    }
+    p7.Content = files["manifest.json"]
+    if err := p7.Verify(); err != nil {
+        t.Fatalf("signature does not verify: %v", err)
+    }
+    roots := x509.NewCertPool()
+    roots.AddCert(wwdr)
+    if err := p7.VerifyWithChain(roots); err != nil {
+        t.Fatalf("signature does not chain to the WWDR certificate: %v", err)
+    }
+    if signerCert := p7.GetOnlySigner(); signerCert == nil || !signerCert.Equal(passCert) {
+        t.Error("signature is not made with the pass certificate")
+    }
+
+    // A tampered manifest must not verify
+    p7.Content = append([]byte(nil), files["manifest.json"]...)
+    p7.Content[len(p7.Content)-2] ^= 1
+    if err := p7.Verify(); err == nil {
+        t.Error("signature verifies against a tampered manifest")
+    }
+}
+
+func TestGoogleWalletSaveJWT(t *testing.T) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    account, err := json.Marshal(map[string]string{
+        "type":         "service_account",
+        "client_email": "wallet@test-project.iam.gserviceaccount.com",
+        "private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(t.TempDir(), "service-account.json")
+    if err := os.WriteFile(path, account, 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    t.Setenv("GOOGLE_WALLET_ISSUER_ID", "3388000000012345678")
+    t.Setenv("GOOGLE_WALLET_ISSUER_NAME", "")
+    t.Setenv("GOOGLE_WALLET_SERVICE_ACCOUNT", path)
+
+    signer, err := NewGoogleWalletSigner([]string{"https://tickets.example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    signed, err := signer.SaveJWT(walletTestPass)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+        if token.Method != jwt.SigningMethodRS256 {
+            t.Errorf("signing method = %v, want RS256", token.Header["alg"])
+        }
+        return &key.PublicKey, nil
+    })
+    if err != nil {
+        t.Fatalf("save link JWT does not verify: %v", err)
+    }
+    claims := token.Claims.(jwt.MapClaims)
+    if claims["iss"] != "wallet@test-project.iam.gserviceaccount.com" || claims["aud"] != "google" || claims["typ"] != "savetowallet" {
+        t.Errorf("claims = %v", claims)
+    }
+
+    payload, _ := claims["payload"].(map[string]interface{})
+    objects, _ := payload["eventTicketObjects"].([]interface{})
+    classes, _ := payload["eventTicketClasses"].([]interface{})
+    if len(objects) != 1 || len(classes) != 1 {
+        t.Fatalf("payload = %v, want one class and one object", payload)
+    }
+    object := objects[0].(map[string]interface{})
+    class := classes[0].(map[string]interface{})
+    if class["id"] != "3388000000012345678."+walletTestPass.EventID || object["classId"] != class["id"] {
+        t.Errorf("object class %v, class id %v", object["classId"], class["id"])
+    }
+    barcode, _ := object["barcode"].(map[string]interface{})
+    if barcode["type"] != "QR_CODE" || barcode["value"] != walletTestPass.Code {
+        t.Errorf("barcode = %v, want the ticket code", barcode)
+    }
+
+    // Another key must not verify the link
+    other, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) {
+        return &other.PublicKey, nil
+    }); err == nil {
+        t.Error("save link JWT verifies with an unrelated key")
+    }
+}